@@ -17,6 +17,25 @@ type AppConfig struct {
 	AutoDeleteFrequency string `json:"autoDeleteFrequency"`
 	MaxClients          int    `json:"maxClients"`
 	LogLevel            string `json:"logLevel"`
+	// Close clients idle for more than Timeout seconds (0 disables)
+	Timeout int `json:"timeout"`
+	// TCP keepalive interval in seconds for accepted sockets (0 disables)
+	TCPKeepalive int `json:"tcpKeepalive"`
+	// client-output-buffer-limit: hard and soft limits in bytes, 0 disables
+	OutputBufferHardLimit   int `json:"outputBufferHardLimit"`
+	OutputBufferSoftLimit   int `json:"outputBufferSoftLimit"`
+	OutputBufferSoftSeconds int `json:"outputBufferSoftSeconds"`
+	// hash-max-listpack-entries / hash-max-listpack-value: past these a
+	// hash is converted from the compact encoding to a real hash table
+	HashMaxListpackEntries int `json:"hashMaxListpackEntries"`
+	HashMaxListpackValue   int `json:"hashMaxListpackValue"`
+	// Number of databases, selected with SELECT 0 to databases-1
+	Databases int `json:"databases"`
+	// maxmemory-samples: keys sampled per database by each pool eviction
+	MaxmemorySamples int `json:"maxmemorySamples"`
+	// lfu-log-factor and lfu-decay-time (minutes) for the LFU strategies
+	LfuLogFactor int `json:"lfuLogFactor"`
+	LfuDecayTime int `json:"lfuDecayTime"`
 }
 
 // DefaultConfig returns default configuration values
@@ -25,10 +44,22 @@ func DefaultConfig() *AppConfig {
 		Host:                "0.0.0.0",
 		Port:                7379,
 		KeysLimit:           1000,
-		EvictionStrategy:    "simple-first",
+		EvictionStrategy:    "allkeys-lru",
 		AutoDeleteFrequency: "1s",
 		MaxClients:          20000,
 		LogLevel:            "info",
+		Timeout:             0,
+		TCPKeepalive:        300,
+		// No output buffer limits, as Redis has none for normal clients
+		OutputBufferHardLimit:   0,
+		OutputBufferSoftLimit:   0,
+		OutputBufferSoftSeconds: 0,
+		HashMaxListpackEntries:  128,
+		HashMaxListpackValue:    64,
+		Databases:               16,
+		MaxmemorySamples:        5,
+		LfuLogFactor:            10,
+		LfuDecayTime:            1,
 	}
 }
 
@@ -45,9 +76,10 @@ func LoadConfig() (*AppConfig, error) {
 		host             = flag.String("host", "", "host for the redis server")
 		port             = flag.Int("port", 0, "port for the redis server")
 		keysLimit        = flag.Int("keys-limit", 0, "maximum key limit")
-		evictionStrategy = flag.String("eviction", "", "eviction strategy (simple-first)")
+		evictionStrategy = flag.String("eviction", "", "eviction policy, a Redis maxmemory-policy name (allkeys-lru, noeviction, ...)")
 		maxClients       = flag.Int("max-clients", 0, "maximum number of clients")
 		logLevel         = flag.String("log-level", "", "log level (info, debug, warn, error)")
+		timeout          = flag.Int("timeout", -1, "close clients idle for this many seconds (0 disables)")
 	)
 
 	flag.Parse()
@@ -89,6 +121,9 @@ func LoadConfig() (*AppConfig, error) {
 	if *logLevel != "" {
 		config.LogLevel = *logLevel
 	}
+	if *timeout >= 0 {
+		config.Timeout = *timeout
+	}
 
 	return config, nil
 }
@@ -112,13 +147,50 @@ func (c *AppConfig) Validate() error {
 		return fmt.Errorf("max clients must be greater than 0: %d", c.MaxClients)
 	}
 
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative: %d", c.Timeout)
+	}
+
+	if c.TCPKeepalive < 0 {
+		return fmt.Errorf("tcp keepalive must not be negative: %d", c.TCPKeepalive)
+	}
+
+	if c.OutputBufferHardLimit < 0 || c.OutputBufferSoftLimit < 0 || c.OutputBufferSoftSeconds < 0 {
+		return fmt.Errorf("output buffer limits must not be negative: hard=%d soft=%d soft-seconds=%d",
+			c.OutputBufferHardLimit, c.OutputBufferSoftLimit, c.OutputBufferSoftSeconds)
+	}
+
+	if c.HashMaxListpackEntries < 0 || c.HashMaxListpackValue < 0 {
+		return fmt.Errorf("hash listpack limits must not be negative: entries=%d value=%d",
+			c.HashMaxListpackEntries, c.HashMaxListpackValue)
+	}
+
+	if c.Databases < 1 {
+		return fmt.Errorf("databases must be greater than 0: %d", c.Databases)
+	}
+
+	if c.MaxmemorySamples < 1 || c.MaxmemorySamples > 64 {
+		return fmt.Errorf("maxmemory samples must be between 1 and 64: %d", c.MaxmemorySamples)
+	}
+
+	if c.LfuLogFactor < 0 || c.LfuDecayTime < 0 {
+		return fmt.Errorf("lfu settings must not be negative: log-factor=%d decay-time=%d",
+			c.LfuLogFactor, c.LfuDecayTime)
+	}
+
 	// Validate auto-delete frequency
 	if _, err := c.GetAutoDeleteDuration(); err != nil {
 		return fmt.Errorf("invalid auto delete frequency: %v", err)
 	}
 
-	// Validate eviction strategy
-	validStrategies := []string{"simple-first", "lru", "random"}
+	// Validate eviction strategy: the Redis maxmemory-policy names
+	validStrategies := []string{
+		"noeviction",
+		"allkeys-lru", "volatile-lru",
+		"allkeys-lfu", "volatile-lfu",
+		"allkeys-random", "volatile-random",
+		"volatile-ttl",
+	}
 	valid := false
 	for _, strategy := range validStrategies {
 		if c.EvictionStrategy == strategy {
@@ -143,5 +215,13 @@ func (c *AppConfig) Print() {
 	fmt.Printf("Auto Delete Frequency: %s\n", c.AutoDeleteFrequency)
 	fmt.Printf("Max Clients: %d\n", c.MaxClients)
 	fmt.Printf("Log Level: %s\n", c.LogLevel)
+	fmt.Printf("Client Timeout: %ds\n", c.Timeout)
+	fmt.Printf("TCP Keepalive: %ds\n", c.TCPKeepalive)
+	fmt.Printf("Output Buffer Limits: hard=%d soft=%d soft-seconds=%d\n",
+		c.OutputBufferHardLimit, c.OutputBufferSoftLimit, c.OutputBufferSoftSeconds)
+	fmt.Printf("Hash Listpack Limits: entries=%d value=%d\n", c.HashMaxListpackEntries, c.HashMaxListpackValue)
+	fmt.Printf("Databases: %d\n", c.Databases)
+	fmt.Printf("Maxmemory Samples: %d\n", c.MaxmemorySamples)
+	fmt.Printf("LFU: log-factor=%d decay-time=%dmin\n", c.LfuLogFactor, c.LfuDecayTime)
 	fmt.Println("===================================")
 }
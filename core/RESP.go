@@ -1,82 +1,177 @@
 package core
 
-func readSimpleString(data []byte) (string, int) {
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrIncomplete is returned when the buffer ends before a full RESP value has
+// been read. The caller should keep the bytes and retry once more arrive.
+var ErrIncomplete = errors.New("incomplete RESP value")
+
+// ErrProtocol is wrapped by every error caused by malformed input. The
+// connection cannot be resynchronised after one, so the client is dropped.
+var ErrProtocol = errors.New("Protocol error")
+
+const (
+	maxBulkLen      = 512 * 1024 * 1024 // proto-max-bulk-len
+	maxMultibulkLen = 1024 * 1024       // max arguments in one request
+	maxNestingDepth = 128               // guards the recursion in readArray
+)
+
+func protocolError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrProtocol, fmt.Sprintf(format, args...))
+}
+
+// readLine returns the bytes between the type byte and the next CRLF, along
+// with the offset just past that CRLF.
+func readLine(data []byte) ([]byte, int, error) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		return nil, 0, ErrIncomplete
+	}
+	return data[1:end], end + 2, nil
+}
+
+// parseLength parses the length header of a bulk string or array. -1 is the
+// null marker; any other negative value, or anything that is not a plain
+// decimal number, is rejected.
+func parseLength(line []byte, max int) (int, bool) {
+	if len(line) == 0 || len(line) > 10 {
+		return 0, false
+	}
+	if len(line) == 2 && line[0] == '-' && line[1] == '1' {
+		return -1, true
+	}
+	n := 0
+	for _, c := range line {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+func readSimpleString(data []byte) (string, int, error) {
 	// fmt.Println("Reading Simple String...")
-	pos := 1
-	for data[pos] != '\r' {
-		pos++
+	line, pos, err := readLine(data)
+	if err != nil {
+		return "", 0, err
 	}
-	result := string(data[1:pos])
+	result := string(line)
 	// fmt.Printf("Simple String parsed: %q\n", result)
-	return result, pos + 2
+	return result, pos, nil
 }
 
-func readArray(data []byte) ([]interface{}, int) {
+// readArray returns nil (not an empty slice) for the null array *-1
+func readArray(data []byte, depth int) ([]interface{}, int, error) {
 	// fmt.Println("Reading Array...")
-	pos := 1
+	if depth > maxNestingDepth {
+		return nil, 0, protocolError("too many nested arrays")
+	}
+	line, pos, err := readLine(data)
+	if err != nil {
+		return nil, 0, err
+	}
 	// Read array length
-	strLen := 0
-	for data[pos] != '\r' {
-		strLen = strLen*10 + int(data[pos]-'0')
-		pos++
+	strLen, ok := parseLength(line, maxMultibulkLen)
+	if !ok {
+		return nil, 0, protocolError("invalid multibulk length")
+	}
+	if strLen == -1 {
+		return nil, pos, nil
 	}
-	pos += 2 // Skip \r\n
 	// fmt.Printf("Array length: %d\n", strLen)
 
-	var elems []interface{} = make([]interface{}, strLen)
+	// Don't trust the header for the allocation, the elements may never come
+	var elems []interface{} = make([]interface{}, 0, min(strLen, 1024))
 	for i := 0; i < strLen; i++ {
-		ele, delta := DecodeOne(data[pos:])
-		elems[i] = ele
+		ele, delta, err := decodeOne(data[pos:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		elems = append(elems, ele)
 		pos += delta
 	}
 	// fmt.Printf("Array parsed: %v\n", elems)
-	return elems, pos
+	return elems, pos, nil
 }
 
-func readBulkString(data []byte) (string, int) {
+// readBulkString returns nil for the null bulk string $-1 and a string otherwise
+func readBulkString(data []byte) (interface{}, int, error) {
 	// fmt.Println("Reading Bulk String...")
-	pos := 1
+	line, pos, err := readLine(data)
+	if err != nil {
+		return nil, 0, err
+	}
 	// Read string length
-	strLen := 0
-	for data[pos] != '\r' {
-		strLen = strLen*10 + int(data[pos]-'0')
-		pos++
+	strLen, ok := parseLength(line, maxBulkLen)
+	if !ok {
+		return nil, 0, protocolError("invalid bulk length")
+	}
+	if strLen == -1 {
+		return nil, pos, nil
 	}
-	pos += 2 // Skip \r\n after length
 	// fmt.Printf("Bulk String length: %d\n", strLen)
 
+	// The payload and its trailing \r\n may not have arrived yet
+	if len(data) < pos+strLen+2 {
+		return nil, 0, ErrIncomplete
+	}
+	if data[pos+strLen] != '\r' || data[pos+strLen+1] != '\n' {
+		return nil, 0, protocolError("bulk string is not terminated by CRLF")
+	}
+
 	// Extract the string
 	result := string(data[pos : pos+strLen])
 	pos += strLen + 2 // Skip string content + \r\n
 	// fmt.Printf("Bulk String parsed: %q\n", result)
-	return result, pos
+	return result, pos, nil
 }
 
-func readError(data []byte) (string, int) {
+func readError(data []byte) (string, int, error) {
 	// fmt.Println("Reading Error...")
-	result, delta := readSimpleString(data)
+	result, delta, err := readSimpleString(data)
 	// fmt.Printf("Error parsed: %q\n", result)
-	return result, delta
+	return result, delta, err
 }
 
-func readInt64(data []byte) (int64, int) {
+func readInt64(data []byte) (int64, int, error) {
 	// fmt.Println("Reading Integer...")
-	pos := 1
-	var value int64 = 0
-	for data[pos] != '\r' {
-		value = value*10 + int64(data[pos]-'0')
-		pos++
+	line, pos, err := readLine(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	value, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, 0, protocolError("invalid integer %q", line)
 	}
 	// fmt.Printf("Integer parsed: %d\n", value)
-	return value, pos + 2
+	return value, pos, nil
 }
 
-func DecodeOne(data []byte) (interface{}, int) {
+// DecodeOne decodes the first RESP value in data and returns it together with
+// the number of bytes it occupied. ErrIncomplete means data holds only a
+// prefix of the value; errors wrapping ErrProtocol mean it is malformed.
+func DecodeOne(data []byte) (interface{}, int, error) {
+	return decodeOne(data, 0)
+}
+
+func decodeOne(data []byte, depth int) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrIncomplete
+	}
 	switch data[0] {
 	case '+':
 		return readSimpleString(data)
 	case '*':
-		return readArray(data)
+		return readArray(data, depth)
 	case '$':
 		return readBulkString(data)
 	case '-':
@@ -85,26 +180,188 @@ func DecodeOne(data []byte) (interface{}, int) {
 		return readInt64(data)
 	default:
 		// fmt.Printf("Unknown RESP type: %c\n", data[0])
-		return string(data), len(data)
+		return nil, 0, protocolError("unknown type byte '%c'", data[0])
 	}
 }
 
 func RESPParser(data []byte) interface{} {
 	// fmt.Printf("Parsing RESP data: %q\n", string(data))
-	value, _ := DecodeOne(data)
+	value, _, _ := DecodeOne(data)
 	return value
 }
 
-// This function will read the Byte array the client sent
-// and will return the array of strings into tokens
-func DecodeCmd(ReadBuffer []byte) ([]string, error) {
+// This function will read the first command from the client's query buffer
+// and will return its tokens along with the number of bytes consumed.
+// A command must be an array of bulk strings, the same as Redis expects:
+// ErrIncomplete means it has not fully arrived yet, and anything else is
+// reported as a protocol error.
+func DecodeCmd(ReadBuffer []byte) ([]string, int, error) {
 	// fmt.Printf("Decoding command from buffer: %q\n", string(ReadBuffer))
-	value, _ := DecodeOne(ReadBuffer)
-	ts := value.([]interface{})
-	tokens := make([]string, len(ts))
-	for i := range tokens {
-		tokens[i] = ts[i].(string)
+	if len(ReadBuffer) == 0 {
+		return nil, 0, ErrIncomplete
+	}
+	if ReadBuffer[0] != '*' {
+		return nil, 0, protocolError("expected '*', got '%c'", ReadBuffer[0])
+	}
+	line, pos, err := readLine(ReadBuffer)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, ok := parseLength(line, maxMultibulkLen)
+	if !ok {
+		return nil, 0, protocolError("invalid multibulk length")
+	}
+
+	tokens := make([]string, 0, max(min(count, 1024), 0))
+	for i := 0; i < count; i++ {
+		if pos >= len(ReadBuffer) {
+			return nil, 0, ErrIncomplete
+		}
+		if ReadBuffer[pos] != '$' {
+			return nil, 0, protocolError("expected '$', got '%c'", ReadBuffer[pos])
+		}
+		value, delta, err := readBulkString(ReadBuffer[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		token, ok := value.(string)
+		if !ok {
+			return nil, 0, protocolError("invalid bulk length")
+		}
+		tokens = append(tokens, token)
+		pos += delta
 	}
 	// fmt.Printf("Decoded command tokens: %v\n", tokens)
-	return tokens, nil
+	return tokens, pos, nil
+}
+
+// maxInlineLen is the longest inline request we buffer waiting for a newline
+const maxInlineLen = 64 * 1024
+
+// DecodeInlineCmd reads one inline command, the plain text form that telnet
+// and nc users type, such as `SET foo "hello world"`. Like DecodeCmd it
+// returns the tokens and the bytes consumed, or ErrIncomplete until the
+// terminating newline has arrived. Blank lines decode to no tokens.
+func DecodeInlineCmd(ReadBuffer []byte) ([]string, int, error) {
+	nl := bytes.IndexByte(ReadBuffer, '\n')
+	if nl < 0 {
+		if len(ReadBuffer) > maxInlineLen {
+			return nil, 0, protocolError("too big inline request")
+		}
+		return nil, 0, ErrIncomplete
+	}
+	line := ReadBuffer[:nl]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	tokens, ok := splitArgs(line)
+	if !ok {
+		return nil, 0, protocolError("unbalanced quotes in request")
+	}
+	return tokens, nl + 1, nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// splitArgs splits an inline request into arguments following the rules of
+// Redis' sdssplitargs: arguments are separated by whitespace, "double quoted"
+// arguments understand \n \r \t \b \a \\ \" and \xHH escapes, and 'single
+// quoted' ones only \'. A closing quote must be followed by whitespace or the
+// end of the line. It reports false for unbalanced quotes.
+func splitArgs(line []byte) ([]string, bool) {
+	var args []string
+	p := 0
+	for {
+		// skip blanks
+		for p < len(line) && (line[p] == ' ' || line[p] == '\t' || line[p] == '\r' || line[p] == '\n' || line[p] == '\v' || line[p] == '\f') {
+			p++
+		}
+		if p == len(line) {
+			return args, true
+		}
+
+		var current []byte
+		inq, insq, done := false, false, false
+		for !done {
+			if inq {
+				if p == len(line) {
+					return nil, false // unterminated quotes
+				}
+				if line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' && isHexDigit(line[p+2]) && isHexDigit(line[p+3]) {
+					current = append(current, hexDigitToInt(line[p+2])*16+hexDigitToInt(line[p+3]))
+					p += 3
+				} else if line[p] == '\\' && p+1 < len(line) {
+					p++
+					switch line[p] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[p])
+					}
+				} else if line[p] == '"' {
+					// closing quote must be followed by a space or nothing at all
+					if p+1 < len(line) && line[p+1] != ' ' && line[p+1] != '\t' {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[p])
+				}
+			} else if insq {
+				if p == len(line) {
+					return nil, false // unterminated quotes
+				}
+				if line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					current = append(current, '\'')
+				} else if line[p] == '\'' {
+					if p+1 < len(line) && line[p+1] != ' ' && line[p+1] != '\t' {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[p])
+				}
+			} else {
+				if p == len(line) {
+					break
+				}
+				switch line[p] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					current = append(current, line[p])
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		args = append(args, string(current))
+	}
 }
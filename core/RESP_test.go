@@ -0,0 +1,102 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decoderSeeds are the malformed and boundary requests both fuzz targets
+// start from
+var decoderSeeds = []string{
+	"*1\r\n$4\r\nPING\r\n",
+	"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nhello\r\n",
+	"*0\r\n",
+	"*-1\r\n",
+	// truncated bulk lengths and payloads
+	"*1\r\n$",
+	"*1\r\n$4",
+	"*1\r\n$4\r\n",
+	"*1\r\n$4\r\nPI",
+	"*1\r\n$4\r\nPING",
+	"*1\r\n$4\r\nPING\r",
+	"*2\r\n$4\r\nECHO\r\n",
+	// negative and huge lengths
+	"*-2\r\n",
+	"*1\r\n$-1\r\n",
+	"*1\r\n$-5\r\nhello\r\n",
+	"*99999999999\r\n",
+	"*1048577\r\n",
+	"*1\r\n$536870913\r\n",
+	"*1\r\n$9223372036854775807\r\n",
+	"*2147483647\r\n$1\r\na\r\n",
+	// missing or broken CRLF
+	"*1\n$4\nPING\n",
+	"*1\r\n$4\r\nPINGxx",
+	"*1\r\n$4\r\nPING\n\r",
+	"*1\r\n$\r\n\r\n",
+	// nested arrays and other types where a bulk string is expected
+	"*1\r\n*1\r\n$4\r\nPING\r\n",
+	"*2\r\n*-1\r\n*0\r\n",
+	"*1\r\n:1\r\n",
+	"*1\r\n+OK\r\n",
+	"*1\r\n-ERR\r\n",
+	// inline requests
+	"PING\r\n",
+	"SET foo \"hello world\"\r\n",
+	"SET foo 'it\\'s'\n",
+	"SET foo \"\\x41\\x4\"\r\n",
+	"SET foo \"unterminated\r\n",
+	"SET foo \"a\"b\r\n",
+	"   \r\n",
+	"\n",
+	"\"\\",
+}
+
+// checkConsumed fails the test when a decoder claimed bytes it was not given,
+// or when its result depends on bytes past the ones it consumed
+func checkConsumed(t *testing.T, decode func([]byte) ([]string, int, error), data []byte, tokens []string, n int, err error) {
+	t.Helper()
+	if err != nil {
+		if n != 0 {
+			t.Fatalf("%q: consumed %d bytes along with error %v", data, n, err)
+		}
+		if err != ErrIncomplete && !errors.Is(err, ErrProtocol) {
+			t.Fatalf("%q: unexpected error %v", data, err)
+		}
+		return
+	}
+	if n <= 0 || n > len(data) {
+		t.Fatalf("%q: consumed %d bytes of %d", data, n, len(data))
+	}
+	// Decoding exactly the consumed prefix must give the same command, so
+	// nothing beyond it was looked at
+	again, n2, err2 := decode(data[:n:n])
+	if err2 != nil || n2 != n || !reflect.DeepEqual(again, tokens) {
+		t.Fatalf("%q: prefix of %d bytes decoded to %q, %d, %v; want %q", data, n, again, n2, err2, tokens)
+	}
+}
+
+func FuzzDecodeCmd(f *testing.F) {
+	for _, seed := range decoderSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tokens, n, err := DecodeCmd(data)
+		checkConsumed(t, DecodeCmd, data, tokens, n, err)
+	})
+}
+
+func FuzzDecodeInlineCmd(f *testing.F) {
+	for _, seed := range decoderSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tokens, n, err := DecodeInlineCmd(data)
+		checkConsumed(t, DecodeInlineCmd, data, tokens, n, err)
+		// An inline request never spans more than one line
+		if err == nil && data[n-1] != '\n' {
+			t.Fatalf("%q: consumed %d bytes, not up to a newline", data, n)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"container/heap"
+	"math"
+	"strconv"
+	"time"
+)
+
+// A blocking command parks its client instead of replying: the handler
+// calls blockForKeys and returns nil. Write commands signal the keys they
+// touched as ready, and once the current command is done every client
+// waiting on a ready key is offered the key again, oldest first. Clients
+// whose deadline passes are answered with their timeout reply. Either way
+// the reply is stored on the client and the server collects it through
+// UnblockedClients.
+
+// blockedState describes what a parked client is waiting for
+type blockedState struct {
+	db       *redisDb // the keys are keys of this database
+	keys     []string
+	deadline int64 // unix time in ms, 0 to wait forever
+	// serve is called when one of the keys may be ready. It returns the
+	// reply to send, or nil if the client has to keep waiting.
+	serve func(c *Client, key string) []byte
+	// timeoutReply is sent when the deadline passes
+	timeoutReply []byte
+}
+
+// readyKey is a key of a database that was written to
+type readyKey struct {
+	db  *redisDb
+	key string
+}
+
+// readyKeys are the keys written since the blocked clients were last served
+var readyKeys []readyKey
+var readyKeySet map[readyKey]bool
+
+// unblockedClients have a reply waiting to be sent by the server
+var unblockedClients []*Client
+
+// blockTimeouts orders the clients that block with a deadline, soonest
+// first. Entries of clients unblocked in the meantime are dropped lazily.
+var blockTimeouts timeoutHeap
+
+type timeoutEntry struct {
+	deadline int64
+	client   *Client
+}
+
+type timeoutHeap []timeoutEntry
+
+func (h timeoutHeap) Len() int            { return len(h) }
+func (h timeoutHeap) Less(i, j int) bool  { return h[i].deadline < h[j].deadline }
+func (h timeoutHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timeoutHeap) Push(x interface{}) { *h = append(*h, x.(timeoutEntry)) }
+func (h *timeoutHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+func init() {
+	readyKeySet = make(map[readyKey]bool)
+}
+
+// IsBlocked reports whether the client waits in a blocking command; the
+// server does not run its next commands until it is unblocked
+func (c *Client) IsBlocked() bool {
+	return c.blocked != nil
+}
+
+// TakeBlockedReply returns the reply of the blocking command the client was
+// released from, and forgets it
+func (c *Client) TakeBlockedReply() []byte {
+	reply := c.blockedReply
+	c.blockedReply = nil
+	return reply
+}
+
+// parseBlockTimeout parses the timeout of a blocking command, in seconds
+// with decimals, into a deadline in unix ms. 0 means no deadline.
+func parseBlockTimeout(arg string) (int64, []byte) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/1000 {
+		return 0, []byte("-ERR timeout is not a float or out of range\r\n")
+	}
+	if seconds < 0 {
+		return 0, []byte("-ERR timeout is negative\r\n")
+	}
+	ms := int64(seconds * 1000)
+	if ms == 0 {
+		return 0, nil
+	}
+	now := time.Now().UnixMilli()
+	if ms > math.MaxInt64-now {
+		return 0, []byte("-ERR timeout is out of range\r\n")
+	}
+	return now + ms, nil
+}
+
+// blockForKeys parks c until serve accepts one of keys or the deadline
+// passes. The calling handler must then return nil instead of a reply.
+func blockForKeys(c *Client, keys []string, deadline int64, serve func(c *Client, key string) []byte, timeoutReply []byte) {
+	c.blocked = &blockedState{
+		db:           db,
+		deadline:     deadline,
+		serve:        serve,
+		timeoutReply: timeoutReply,
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		c.blocked.keys = append(c.blocked.keys, key)
+		db.blockingKeys[key] = append(db.blockingKeys[key], c)
+	}
+	if deadline > 0 {
+		heap.Push(&blockTimeouts, timeoutEntry{deadline: deadline, client: c})
+	}
+}
+
+// removeBlocked takes c out of the queues of the keys it waits on
+func removeBlocked(c *Client) {
+	blockingKeys := c.blocked.db.blockingKeys
+	for _, key := range c.blocked.keys {
+		queue := blockingKeys[key]
+		for i, other := range queue {
+			if other == c {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(blockingKeys, key)
+		} else {
+			blockingKeys[key] = queue
+		}
+	}
+	c.blocked = nil
+}
+
+// unblockClient releases c with reply, which the server sends next
+func unblockClient(c *Client, reply []byte) {
+	removeBlocked(c)
+	c.blockedReply = reply
+	unblockedClients = append(unblockedClients, c)
+}
+
+// signalKeyAsReady marks key of the current database as written, if
+// anybody waits on it
+func signalKeyAsReady(key string) {
+	signalKeyAsReadyIn(db, key)
+}
+
+// signalKeyAsReadyIn is signalKeyAsReady for a key of database d
+func signalKeyAsReadyIn(d *redisDb, key string) {
+	rk := readyKey{d, key}
+	if _, ok := d.blockingKeys[key]; !ok || readyKeySet[rk] {
+		return
+	}
+	readyKeySet[rk] = true
+	readyKeys = append(readyKeys, rk)
+}
+
+// handleClientsBlockedOnKeys offers every ready key to the clients waiting
+// on it, first come first served. Serving a client may write to other keys
+// (or empty the key again), so it goes on until no key is left ready.
+func handleClientsBlockedOnKeys() {
+	// Clients are served in the database they blocked in
+	current := db
+	defer func() { db = current }()
+	for len(readyKeys) > 0 {
+		keys := readyKeys
+		readyKeys = nil
+		readyKeySet = make(map[readyKey]bool)
+		for _, rk := range keys {
+			db = rk.db
+			// Unblocking edits the queue, walk a copy of it
+			queue := append([]*Client(nil), rk.db.blockingKeys[rk.key]...)
+			for _, c := range queue {
+				if c.blocked == nil {
+					continue
+				}
+				if reply := c.blocked.serve(c, rk.key); reply != nil {
+					unblockClient(c, reply)
+				}
+			}
+		}
+	}
+}
+
+// UnblockedClients returns the clients released since the last call which
+// are still connected, and resets the list
+func UnblockedClients() []*Client {
+	var result []*Client
+	for _, c := range unblockedClients {
+		if _, ok := clients[c.ID]; ok {
+			result = append(result, c)
+		}
+	}
+	unblockedClients = nil
+	return result
+}
+
+// TimeoutBlockedClients releases the clients whose deadline has passed.
+// The server calls it on every event loop iteration.
+func TimeoutBlockedClients() {
+	now := time.Now().UnixMilli()
+	for blockTimeouts.Len() > 0 && blockTimeouts[0].deadline <= now {
+		entry := heap.Pop(&blockTimeouts).(timeoutEntry)
+		c := entry.client
+		if c.blocked != nil && c.blocked.deadline == entry.deadline {
+			unblockClient(c, c.blocked.timeoutReply)
+		}
+	}
+}
+
+// NextBlockedTimeout returns the milliseconds until the next blocked client
+// times out, or -1 when none is waiting with a deadline
+func NextBlockedTimeout() int {
+	for blockTimeouts.Len() > 0 {
+		entry := blockTimeouts[0]
+		if entry.client.blocked != nil && entry.client.blocked.deadline == entry.deadline {
+			return int(max(entry.deadline-time.Now().UnixMilli(), 0))
+		}
+		heap.Pop(&blockTimeouts)
+	}
+	return -1
+}
+
+// WAITKEY key [key ...] timeout
+// blocks until a write command touches one of the keys and replies with
+// that key, or with nil once timeout seconds have passed (0 waits forever)
+func evalWAITKEY(Args []string, c *Client) []byte {
+	deadline, errReply := parseBlockTimeout(Args[len(Args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	serve := func(c *Client, key string) []byte {
+		return EncodeProto(key, false, c.Proto)
+	}
+	blockForKeys(c, Args[:len(Args)-1], deadline, serve, EncodeProto(nil, false, c.Proto))
+	return nil
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetServer gives a test fresh databases and no blocked clients, and
+// frees the clients it creates once it is done
+func resetServer(t *testing.T) {
+	t.Helper()
+	createDbs(1)
+	readyKeys = nil
+	readyKeySet = make(map[readyKey]bool)
+	unblockedClients = nil
+	blockTimeouts = nil
+	t.Cleanup(func() {
+		for _, c := range clients {
+			FreeClient(c)
+		}
+		storeConfig = nil
+		createDbs(defaultDatabases)
+	})
+}
+
+// run executes a command typed as a single line and returns its reply
+func run(c *Client, line string) string {
+	args := strings.Fields(line)
+	EvalAndResponse(&RedisCmd{Cmd: strings.ToUpper(args[0]), Args: args[1:]}, c)
+	reply := string(c.Out)
+	c.Out = c.Out[:0]
+	return reply
+}
+
+// wakeup is a client released from a blocking command with its reply
+type wakeup struct {
+	client int
+	reply  string
+}
+
+// released collects the clients the last commands unblocked, in order
+func released(t *testing.T, blocked []*Client) []wakeup {
+	t.Helper()
+	var got []wakeup
+	for _, c := range UnblockedClients() {
+		for i, b := range blocked {
+			if b == c {
+				got = append(got, wakeup{i, string(c.TakeBlockedReply())})
+			}
+		}
+	}
+	return got
+}
+
+func TestBlockedClientsWakeup(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []string // one blocking command per client, in arrival order
+		write string
+		want  []wakeup
+	}{
+		{
+			name:  "one element goes to the first client to block",
+			block: []string{"BZPOPMIN z 0", "BZPOPMIN z 0"},
+			write: "ZADD z 1 a",
+			want:  []wakeup{{0, "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n"}},
+		},
+		{
+			name:  "two elements are handed out in arrival order",
+			block: []string{"BZPOPMIN z 0", "BZPOPMAX z 0"},
+			write: "ZADD z 1 a 2 b",
+			want: []wakeup{
+				{0, "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n"},
+				{1, "*3\r\n$1\r\nz\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+			},
+		},
+		{
+			name:  "every client waiting on the key is woken, oldest first",
+			block: []string{"WAITKEY k 0", "WAITKEY k 0", "WAITKEY k 0"},
+			write: "SET k v",
+			want:  []wakeup{{0, "$1\r\nk\r\n"}, {1, "$1\r\nk\r\n"}, {2, "$1\r\nk\r\n"}},
+		},
+		{
+			name:  "a write to one of several keys wakes the clients on that key",
+			block: []string{"WAITKEY k1 0", "WAITKEY k2 0", "WAITKEY k3 k2 0"},
+			write: "SET k2 v",
+			want:  []wakeup{{1, "$2\r\nk2\r\n"}, {2, "$2\r\nk2\r\n"}},
+		},
+		{
+			name:  "a pop on one of several keys serves the client on that key",
+			block: []string{"BZPOPMIN a 0", "BZPOPMIN b 0", "BZPOPMIN c 0"},
+			write: "ZADD b 5 m",
+			want:  []wakeup{{1, "*3\r\n$1\r\nb\r\n$1\r\nm\r\n$1\r\n5\r\n"}},
+		},
+		{
+			name:  "a failed write wakes nobody",
+			block: []string{"BZPOPMIN z 0"},
+			write: "ZADD z notanumber a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			var blocked []*Client
+			for _, cmd := range tt.block {
+				c := NewClient(-1, "", "")
+				if reply := run(c, cmd); reply != "" || !c.IsBlocked() {
+					t.Fatalf("%s: replied %q instead of blocking", cmd, reply)
+				}
+				blocked = append(blocked, c)
+			}
+			run(NewClient(-1, "", ""), tt.write)
+
+			got := released(t, blocked)
+			if len(got) != len(tt.want) {
+				t.Fatalf("woke %v, want %v", got, tt.want)
+			}
+			woken := make(map[int]bool)
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("wakeup %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+				woken[got[i].client] = true
+			}
+			for i, c := range blocked {
+				if c.IsBlocked() == woken[i] {
+					t.Errorf("client %d blocked = %v after the write", i, c.IsBlocked())
+				}
+			}
+		})
+	}
+}
+
+func TestBlockedClientsTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		block []string // commands with their timeouts, in arrival order
+		proto []int
+		want  []wakeup // in the order the deadlines pass
+	}{
+		{
+			name:  "deadlines expire soonest first",
+			block: []string{"WAITKEY k 0.03", "WAITKEY k 0.01", "WAITKEY k 0.02"},
+			proto: []int{2, 2, 2},
+			want:  []wakeup{{1, "$-1\r\n"}, {2, "$-1\r\n"}, {0, "$-1\r\n"}},
+		},
+		{
+			name:  "the nil reply follows the protocol of each client",
+			block: []string{"BZPOPMIN z 0.02", "WAITKEY k 0.01"},
+			proto: []int{2, 3},
+			want:  []wakeup{{1, "_\r\n"}, {0, "*-1\r\n"}},
+		},
+		{
+			name:  "clients without a deadline keep waiting",
+			block: []string{"WAITKEY k 0", "WAITKEY k 0.01"},
+			proto: []int{2, 2},
+			want:  []wakeup{{1, "$-1\r\n"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			var blocked []*Client
+			for i, cmd := range tt.block {
+				c := NewClient(-1, "", "")
+				c.Proto = tt.proto[i]
+				run(c, cmd)
+				blocked = append(blocked, c)
+			}
+			time.Sleep(50 * time.Millisecond)
+			TimeoutBlockedClients()
+
+			got := released(t, blocked)
+			if len(got) != len(tt.want) {
+				t.Fatalf("timed out %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("timeout %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNextBlockedTimeout(t *testing.T) {
+	resetServer(t)
+	if next := NextBlockedTimeout(); next != -1 {
+		t.Fatalf("NextBlockedTimeout() = %d with nobody blocked, want -1", next)
+	}
+
+	late, early, forever := NewClient(-1, "", ""), NewClient(-1, "", ""), NewClient(-1, "", "")
+	run(late, "WAITKEY a 10")
+	run(early, "WAITKEY b 5")
+	run(forever, "WAITKEY c 0")
+	if next := NextBlockedTimeout(); next < 4900 || next > 5000 {
+		t.Fatalf("NextBlockedTimeout() = %d, want about 5000", next)
+	}
+
+	// Once the earliest client is served its deadline no longer counts
+	run(NewClient(-1, "", ""), "SET b v")
+	if next := NextBlockedTimeout(); next < 9900 || next > 10000 {
+		t.Fatalf("NextBlockedTimeout() = %d after serving the earliest, want about 10000", next)
+	}
+	run(NewClient(-1, "", ""), "SET a v")
+	if next := NextBlockedTimeout(); next != -1 {
+		t.Fatalf("NextBlockedTimeout() = %d with only a client without deadline, want -1", next)
+	}
+}
@@ -0,0 +1,419 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ClientFlag uint32
+
+const (
+	// ClientCloseAfterReply asks the server to close the connection once the
+	// pending replies are written (CLIENT KILL on oneself)
+	ClientCloseAfterReply ClientFlag = 1 << iota
+	// ClientCloseASAP marks a client killed by another one
+	ClientCloseASAP
+	// ClientNoEvict is set by CLIENT NO-EVICT on
+	ClientNoEvict
+)
+
+// Client is the per-connection state that commands can read and change.
+// The server owns the socket; core only keeps what the commands need, plus
+// a few figures the server reports back for CLIENT LIST.
+type Client struct {
+	ID    int64
+	FD    int    // -1 when the connection has no raw fd
+	Addr  string // ip:port of the peer
+	LAddr string // ip:port the peer connected to
+	Name  string
+	Proto int // RESP version negotiated with HELLO, 2 until then
+	DB    int
+
+	CreatedAt       time.Time
+	LastInteraction time.Time
+	LastCmd         string
+
+	// Out holds the replies waiting to be written. Commands append to it
+	// directly and the server drains it as the socket accepts them.
+	Out []byte
+
+	// Maintained by the server as it reads and writes
+	QueryBufLen int
+	OutBufLen   int
+
+	Flags ClientFlag
+
+	// Set while the client waits in a blocking command, see blocked.go
+	blocked      *blockedState
+	blockedReply []byte
+}
+
+// nextClientID hands out connection ids, they are never reused
+var nextClientID int64 = 0
+
+// clients holds every connected client by id
+var clients map[int64]*Client
+
+// clientsToClose collects clients killed by someone else; the server closes
+// them on its next loop iteration
+var clientsToClose []*Client
+
+func init() {
+	clients = make(map[int64]*Client)
+}
+
+// NewClient registers a new connection. fd may be -1 when the server does
+// not work with raw file descriptors.
+func NewClient(fd int, addr string, laddr string) *Client {
+	nextClientID++
+	now := time.Now()
+	c := &Client{
+		ID:              nextClientID,
+		FD:              fd,
+		Addr:            addr,
+		LAddr:           laddr,
+		Proto:           2,
+		CreatedAt:       now,
+		LastInteraction: now,
+		LastCmd:         "NULL",
+	}
+	clients[c.ID] = c
+	return c
+}
+
+// FreeClient forgets a client once its connection is closed
+func FreeClient(c *Client) {
+	if c.blocked != nil {
+		removeBlocked(c)
+	}
+	delete(clients, c.ID)
+	c.FD = -1
+}
+
+// ClientsToClose returns the clients killed since the last call which are
+// still connected, and resets the list.
+func ClientsToClose() []*Client {
+	var result []*Client
+	for _, c := range clientsToClose {
+		if _, ok := clients[c.ID]; ok {
+			result = append(result, c)
+		}
+	}
+	clientsToClose = nil
+	return result
+}
+
+// ConnectedClients returns how many clients are registered
+func ConnectedClients() int {
+	return len(clients)
+}
+
+func (c *Client) flagsString() string {
+	flags := ""
+	if c.Flags&ClientCloseAfterReply != 0 {
+		flags += "c"
+	}
+	if c.Flags&ClientCloseASAP != 0 {
+		flags += "A"
+	}
+	if c.Flags&ClientNoEvict != 0 {
+		flags += "e"
+	}
+	if c.blocked != nil {
+		flags += "b"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// infoString renders the client the way CLIENT LIST and CLIENT INFO show it
+func (c *Client) infoString() string {
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=%d sub=0 psub=0 multi=-1 qbuf=%d omem=%d cmd=%s user=default resp=%d",
+		c.ID, c.Addr, c.LAddr, c.FD, c.Name,
+		int64(now.Sub(c.CreatedAt).Seconds()),
+		int64(now.Sub(c.LastInteraction).Seconds()),
+		c.flagsString(), c.DB, c.QueryBufLen, c.OutBufLen,
+		strings.ToLower(c.LastCmd), c.Proto)
+}
+
+// sortedClients returns the connected clients ordered by id
+func sortedClients() []*Client {
+	list := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func validClientType(t string) bool {
+	switch strings.ToLower(t) {
+	case "normal", "master", "replica", "slave", "pubsub":
+		return true
+	}
+	return false
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID id [id ...]]
+func evalClientLIST(Args []string, c *Client) []byte {
+	var ids map[int64]bool
+	clientType := ""
+	if len(Args) == 2 && strings.ToUpper(Args[0]) == "TYPE" {
+		if !validClientType(Args[1]) {
+			return []byte(fmt.Sprintf("-ERR Unknown client type '%s'\r\n", Args[1]))
+		}
+		clientType = strings.ToLower(Args[1])
+	} else if len(Args) >= 2 && strings.ToUpper(Args[0]) == "ID" {
+		ids = make(map[int64]bool)
+		for _, arg := range Args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				return []byte(fmt.Sprintf("-ERR Invalid client ID '%s'\r\n", arg))
+			}
+			ids[id] = true
+		}
+	} else if len(Args) != 0 {
+		return []byte("-ERR syntax error\r\n")
+	}
+
+	var sb strings.Builder
+	for _, other := range sortedClients() {
+		// Every connection is a normal client for now
+		if clientType != "" && clientType != "normal" {
+			continue
+		}
+		if ids != nil && !ids[other.ID] {
+			continue
+		}
+		sb.WriteString(other.infoString())
+		sb.WriteByte('\n')
+	}
+	return c.addReply(VerbatimString{Format: "txt", Text: sb.String()})
+}
+
+// killFilter holds the conditions of CLIENT KILL <filter> <value> ...
+type killFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	ctype  string
+	user   string
+	maxAge int64
+	skipMe bool
+}
+
+func (f *killFilter) matches(other *Client, c *Client) bool {
+	if f.id != 0 && other.ID != f.id {
+		return false
+	}
+	if f.addr != "" && other.Addr != f.addr {
+		return false
+	}
+	if f.laddr != "" && other.LAddr != f.laddr {
+		return false
+	}
+	if f.ctype != "" && f.ctype != "normal" {
+		return false
+	}
+	if f.user != "" && f.user != "default" {
+		return false
+	}
+	if f.maxAge != 0 && int64(time.Since(other.CreatedAt).Seconds()) < f.maxAge {
+		return false
+	}
+	if f.skipMe && other == c {
+		return false
+	}
+	return true
+}
+
+// killClient flags other for disconnection. The caller itself is closed
+// only after its reply has been sent.
+func killClient(other *Client, c *Client) {
+	if other == c {
+		c.Flags |= ClientCloseAfterReply
+		return
+	}
+	if other.Flags&ClientCloseASAP == 0 {
+		other.Flags |= ClientCloseASAP
+		clientsToClose = append(clientsToClose, other)
+	}
+}
+
+// CLIENT KILL ip:port
+// CLIENT KILL <ID id|ADDR ip:port|LADDR ip:port|TYPE type|USER user|SKIPME yes/no|MAXAGE secs> ...
+func evalClientKILL(Args []string, c *Client) []byte {
+	// Old form: a single address, replies +OK or an error
+	if len(Args) == 1 {
+		for _, other := range sortedClients() {
+			if other.Addr == Args[0] {
+				killClient(other, c)
+				return RESP_OK
+			}
+		}
+		return []byte("-ERR No such client\r\n")
+	}
+	if len(Args) == 0 || len(Args)%2 != 0 {
+		return []byte("-ERR syntax error\r\n")
+	}
+
+	filter := killFilter{skipMe: true}
+	for i := 0; i < len(Args); i += 2 {
+		value := Args[i+1]
+		switch strings.ToUpper(Args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return []byte("-ERR client-id should be greater than 0\r\n")
+			}
+			filter.id = id
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "TYPE":
+			if !validClientType(value) {
+				return []byte(fmt.Sprintf("-ERR Unknown client type '%s'\r\n", value))
+			}
+			filter.ctype = strings.ToLower(value)
+		case "USER":
+			filter.user = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return []byte("-ERR syntax error\r\n")
+			}
+		case "MAXAGE":
+			age, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || age <= 0 {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			filter.maxAge = age
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	killed := 0
+	for _, other := range sortedClients() {
+		if filter.matches(other, c) {
+			killClient(other, c)
+			killed++
+		}
+	}
+	return c.addReply(killed)
+}
+
+// CLIENT INFO
+func evalClientINFO(Args []string, c *Client) []byte {
+	return c.addReply(VerbatimString{Format: "txt", Text: c.infoString() + "\n"})
+}
+
+// CLIENT ID
+func evalClientID(Args []string, c *Client) []byte {
+	return c.addReply(c.ID)
+}
+
+// CLIENT SETNAME name, an empty name removes it
+func evalClientSETNAME(Args []string, c *Client) []byte {
+	if !validClientName(Args[0]) {
+		return []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+	}
+	c.Name = Args[0]
+	return RESP_OK
+}
+
+// CLIENT GETNAME
+func evalClientGETNAME(Args []string, c *Client) []byte {
+	if c.Name == "" {
+		return c.addReply(nil)
+	}
+	return c.addReply(c.Name)
+}
+
+// CLIENT NO-EVICT on|off
+func evalClientNOEVICT(Args []string, c *Client) []byte {
+	switch strings.ToLower(Args[0]) {
+	case "on":
+		c.Flags |= ClientNoEvict
+	case "off":
+		c.Flags &^= ClientNoEvict
+	default:
+		return []byte("-ERR syntax error\r\n")
+	}
+	return RESP_OK
+}
+
+// CLIENT UNBLOCK client-id [TIMEOUT|ERROR]
+func evalClientUNBLOCK(Args []string, c *Client) []byte {
+	id, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	withError := false
+	if len(Args) == 2 {
+		switch strings.ToUpper(Args[1]) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			return []byte("-ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR\r\n")
+		}
+	} else if len(Args) > 2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	other, ok := clients[id]
+	if !ok || other.blocked == nil {
+		return c.addReply(0)
+	}
+	if withError {
+		unblockClient(other, []byte("-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n"))
+	} else {
+		unblockClient(other, other.blocked.timeoutReply)
+	}
+	return c.addReply(1)
+}
+
+// maxStreamedReply bounds a streamed reply when no hard output buffer
+// limit is configured
+const maxStreamedReply = 1024 * 1024 * 1024
+
+// outputBufferHardLimit is the hard output buffer limit the server closes
+// clients at, 0 for none
+var outputBufferHardLimit int
+
+// SetOutputBufferHardLimit is how the server tells which hard output buffer
+// limit it enforces, so that streamed replies stop at the same point
+func SetOutputBufferHardLimit(limit int) {
+	outputBufferHardLimit = limit
+}
+
+// outputLimitReached reports whether c.Out went past the hard output buffer
+// limit. Commands whose reply size is up to the client, such as HRANDFIELD
+// with a negative count, stream it and check this as they go. Past the
+// limit they stop, and the client is closed as the server would do on
+// seeing the buffer; it would never read the rest anyway.
+func (c *Client) outputLimitReached() bool {
+	limit := maxStreamedReply
+	if outputBufferHardLimit > 0 {
+		limit = outputBufferHardLimit
+	}
+	if len(c.Out) <= limit {
+		return false
+	}
+	if c.Flags&ClientCloseASAP == 0 {
+		c.Flags |= ClientCloseASAP
+		clientsToClose = append(clientsToClose, c)
+	}
+	return true
+}
@@ -0,0 +1,806 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CommandHandler evaluates a command. Args excludes the command name (and
+// the subcommand name for container commands like CLIENT) and has already
+// passed the arity check.
+type CommandHandler func(Args []string, c *Client) []byte
+
+type CommandFlag uint32
+
+const (
+	CmdWrite    CommandFlag = 1 << iota // may modify the keyspace
+	CmdReadonly                         // only reads keys
+	CmdDenyOOM                          // may use more memory, refused when full
+	CmdAdmin                            // administrative command
+	CmdNoScript                         // not allowed from scripts
+	CmdBlocking                         // may block the client
+	CmdLoading                          // allowed while loading the dataset
+	CmdStale                            // allowed on a replica with stale data
+	CmdFast                             // O(1) or O(log N)
+	CmdNoAuth                           // allowed before authenticating
+)
+
+// flagNames are the names COMMAND reports, in the order it reports them
+var flagNames = []struct {
+	flag CommandFlag
+	name string
+}{
+	{CmdWrite, "write"},
+	{CmdReadonly, "readonly"},
+	{CmdDenyOOM, "denyoom"},
+	{CmdAdmin, "admin"},
+	{CmdNoScript, "noscript"},
+	{CmdBlocking, "blocking"},
+	{CmdLoading, "loading"},
+	{CmdStale, "stale"},
+	{CmdFast, "fast"},
+	{CmdNoAuth, "no_auth"},
+}
+
+// Command describes one entry of the command table
+type Command struct {
+	Name    string // lower case, e.g. "get" or "setname" for CLIENT SETNAME
+	Handler CommandHandler
+	// Arity counts the whole argv including the command (and subcommand)
+	// name, as in Redis: N means exactly N, -N means at least N
+	Arity int
+	Flags CommandFlag
+	// Key positions in argv: first key, last key (negative counts from the
+	// end) and the step between keys. All zero for commands without keys.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// KeysFunc overrides the fixed positions for commands whose keys depend
+	// on the arguments (numkeys style). It returns positions in argv.
+	KeysFunc func(argv []string) []int
+
+	Group   string // generic, string, connection, server...
+	Summary string
+	Since   string
+
+	Subcommands map[string]*Command // keyed by upper case name
+	parent      *Command
+}
+
+// commandTable holds every command by upper case name
+var commandTable map[string]*Command
+
+func init() {
+	commandTable = make(map[string]*Command)
+	for _, cmd := range []*Command{
+		{Name: "ping", Handler: evalPING, Arity: -1, Flags: CmdFast,
+			Group: "connection", Summary: "Returns the server's liveliness response.", Since: "1.0.0"},
+		{Name: "echo", Handler: evalECHO, Arity: 2, Flags: CmdFast,
+			Group: "connection", Summary: "Returns the given string.", Since: "1.0.0"},
+		{Name: "time", Handler: evalTIME, Arity: 1, Flags: CmdLoading | CmdStale | CmdFast,
+			Group: "server", Summary: "Returns the server time.", Since: "2.6.0"},
+		{Name: "set", Handler: evalSET, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", Since: "1.0.0"},
+		{Name: "get", Handler: evalGET, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns the string value of a key.", Since: "1.0.0"},
+		{Name: "setnx", Handler: evalSETNX, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Set the string value of a key only when the key doesn't exist.", Since: "1.0.0"},
+		{Name: "setex", Handler: evalSETEX, Arity: 4, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.", Since: "2.0.0"},
+		{Name: "psetex", Handler: evalPSETEX, Arity: 4, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Sets both string value and expiration time in milliseconds of a key. The key is created if it doesn't exist.", Since: "2.6.0"},
+		{Name: "getset", Handler: evalGETSET, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns the previous string value of a key after setting it to a new value.", Since: "1.0.0"},
+		{Name: "getdel", Handler: evalGETDEL, Arity: 2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns the string value of a key after deleting the key.", Since: "6.2.0"},
+		{Name: "getex", Handler: evalGETEX, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns the string value of a key after setting its expiration time.", Since: "6.2.0"},
+		{Name: "append", Handler: evalAPPEND, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.", Since: "2.0.0"},
+		{Name: "strlen", Handler: evalSTRLEN, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns the length of a string value.", Since: "2.2.0"},
+		{Name: "getrange", Handler: evalGETRANGE, Arity: 4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Returns a substring of the string stored at a key.", Since: "2.4.0"},
+		{Name: "setrange", Handler: evalSETRANGE, Arity: 4, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.", Since: "2.2.0"},
+		{Name: "mget", Handler: evalMGET, Arity: -2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "string", Summary: "Atomically returns the string values of one or more keys.", Since: "1.0.0"},
+		{Name: "mset", Handler: evalMSET, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: -1, KeyStep: 2,
+			Group: "string", Summary: "Atomically creates or modifies the string values of one or more keys.", Since: "1.0.1"},
+		{Name: "msetnx", Handler: evalMSETNX, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: -1, KeyStep: 2,
+			Group: "string", Summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.", Since: "1.0.1"},
+		{Name: "incr", Handler: evalINCR, Arity: 2, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0"},
+		{Name: "decr", Handler: evalDECR, Arity: 2, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0"},
+		{Name: "incrby", Handler: evalINCRBY, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0"},
+		{Name: "decrby", Handler: evalDECRBY, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.", Since: "1.0.0"},
+		{Name: "incrbyfloat", Handler: evalINCRBYFLOAT, Arity: 3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "string", Summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.", Since: "2.6.0"},
+		{Name: "lpush", Handler: evalLPUSH, Arity: -3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", Since: "1.0.0"},
+		{Name: "rpush", Handler: evalRPUSH, Arity: -3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", Since: "1.0.0"},
+		{Name: "lpushx", Handler: evalLPUSHX, Arity: -3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Prepends one or more elements to a list only when the list exists.", Since: "2.2.0"},
+		{Name: "rpushx", Handler: evalRPUSHX, Arity: -3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Appends an element to a list only when the list exists.", Since: "2.2.0"},
+		{Name: "lpop", Handler: evalLPOP, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", Since: "1.0.0"},
+		{Name: "rpop", Handler: evalRPOP, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns and removes the last elements of the list. Deletes the list if the last element was popped.", Since: "1.0.0"},
+		{Name: "llen", Handler: evalLLEN, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns the length of a list.", Since: "1.0.0"},
+		{Name: "lrange", Handler: evalLRANGE, Arity: 4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns a range of elements from a list.", Since: "1.0.0"},
+		{Name: "lindex", Handler: evalLINDEX, Arity: 3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns an element from a list by its index.", Since: "1.0.0"},
+		{Name: "lset", Handler: evalLSET, Arity: 4, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Sets the value of an element in a list by its index.", Since: "1.0.0"},
+		{Name: "lrem", Handler: evalLREM, Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Removes elements from a list. Deletes the list if the last element was removed.", Since: "1.0.0"},
+		{Name: "ltrim", Handler: evalLTRIM, Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", Since: "1.0.0"},
+		{Name: "linsert", Handler: evalLINSERT, Arity: 5, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Inserts an element before or after another element in a list.", Since: "2.2.0"},
+		{Name: "lpos", Handler: evalLPOS, Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "list", Summary: "Returns the index of matching elements in a list.", Since: "6.0.6"},
+		{Name: "lmove", Handler: evalLMOVE, Arity: 5, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "list", Summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", Since: "6.2.0"},
+		{Name: "rpoplpush", Handler: evalRPOPLPUSH, Arity: 3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "list", Summary: "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.", Since: "1.2.0"},
+		{Name: "lmpop", Handler: evalLMPOP, Arity: -4, Flags: CmdWrite, KeysFunc: numKeysPositions,
+			Group: "list", Summary: "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", Since: "7.0.0"},
+		{Name: "hset", Handler: evalHSET, Arity: -4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Creates or modifies the value of a field in a hash.", Since: "2.0.0"},
+		{Name: "hsetnx", Handler: evalHSETNX, Arity: 4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Sets the value of a field in a hash only when the field doesn't exist.", Since: "2.0.0"},
+		{Name: "hmset", Handler: evalHMSET, Arity: -4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Sets the values of multiple fields.", Since: "2.0.0"},
+		{Name: "hget", Handler: evalHGET, Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns the value of a field in a hash.", Since: "2.0.0"},
+		{Name: "hmget", Handler: evalHMGET, Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns the values of all fields in a hash.", Since: "2.0.0"},
+		{Name: "hdel", Handler: evalHDEL, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", Since: "2.0.0"},
+		{Name: "hlen", Handler: evalHLEN, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns the number of fields in a hash.", Since: "2.0.0"},
+		{Name: "hexists", Handler: evalHEXISTS, Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Determines whether a field exists in a hash.", Since: "2.0.0"},
+		{Name: "hstrlen", Handler: evalHSTRLEN, Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns the length of the value of a field.", Since: "3.2.0"},
+		{Name: "hgetall", Handler: evalHGETALL, Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns all fields and values in a hash.", Since: "2.0.0"},
+		{Name: "hkeys", Handler: evalHKEYS, Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns all fields in a hash.", Since: "2.0.0"},
+		{Name: "hvals", Handler: evalHVALS, Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns all values in a hash.", Since: "2.0.0"},
+		{Name: "hincrby", Handler: evalHINCRBY, Arity: 4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", Since: "2.0.0"},
+		{Name: "hincrbyfloat", Handler: evalHINCRBYFLOAT, Arity: 4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", Since: "2.6.0"},
+		{Name: "hrandfield", Handler: evalHRANDFIELD, Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Returns one or more random fields from a hash.", Since: "6.2.0"},
+		{Name: "hscan", Handler: evalHSCAN, Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "hash", Summary: "Iterates over fields and values of a hash.", Since: "2.8.0"},
+		{Name: "sadd", Handler: evalSADD, Arity: -3, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", Since: "1.0.0"},
+		{Name: "srem", Handler: evalSREM, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Removes one or more members from a set. Deletes the set if the last member was removed.", Since: "1.0.0"},
+		{Name: "smembers", Handler: evalSMEMBERS, Arity: 2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Returns all members of a set.", Since: "1.0.0"},
+		{Name: "sismember", Handler: evalSISMEMBER, Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Determines whether a member belongs to a set.", Since: "1.0.0"},
+		{Name: "smismember", Handler: evalSMISMEMBER, Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Determines whether multiple members belong to a set.", Since: "6.2.0"},
+		{Name: "scard", Handler: evalSCARD, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Returns the number of members in a set.", Since: "1.0.0"},
+		{Name: "sinter", Handler: evalSINTER, Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Returns the intersect of multiple sets.", Since: "1.0.0"},
+		{Name: "sunion", Handler: evalSUNION, Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Returns the union of multiple sets.", Since: "1.0.0"},
+		{Name: "sdiff", Handler: evalSDIFF, Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Returns the difference of multiple sets.", Since: "1.0.0"},
+		{Name: "sinterstore", Handler: evalSINTERSTORE, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Stores the intersect of multiple sets in a key.", Since: "1.0.0"},
+		{Name: "sunionstore", Handler: evalSUNIONSTORE, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Stores the union of multiple sets in a key.", Since: "1.0.0"},
+		{Name: "sdiffstore", Handler: evalSDIFFSTORE, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "set", Summary: "Stores the difference of multiple sets in a key.", Since: "1.0.0"},
+		{Name: "srandmember", Handler: evalSRANDMEMBER, Arity: -2, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Get one or multiple random members from a set", Since: "1.0.0"},
+		{Name: "spop", Handler: evalSPOP, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", Since: "1.0.0"},
+		{Name: "smove", Handler: evalSMOVE, Arity: 4, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "set", Summary: "Moves a member from one set to another.", Since: "1.0.0"},
+		{Name: "sscan", Handler: evalSSCAN, Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "set", Summary: "Iterates over members of a set.", Since: "2.8.0"},
+		{Name: "sintercard", Handler: evalSINTERCARD, Arity: -3, Flags: CmdReadonly, KeysFunc: numKeysPositions,
+			Group: "set", Summary: "Returns the number of members of the intersect of multiple sets.", Since: "7.0.0"},
+		{Name: "zadd", Handler: evalZADD, Arity: -4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.", Since: "1.2.0"},
+		{Name: "zincrby", Handler: evalZINCRBY, Arity: 4, Flags: CmdWrite | CmdDenyOOM | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Increments the score of a member in a sorted set.", Since: "1.2.0"},
+		{Name: "zrem", Handler: evalZREM, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.", Since: "1.2.0"},
+		{Name: "zcard", Handler: evalZCARD, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the number of members in a sorted set.", Since: "1.2.0"},
+		{Name: "zscore", Handler: evalZSCORE, Arity: 3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the score of a member in a sorted set.", Since: "1.2.0"},
+		{Name: "zmscore", Handler: evalZMSCORE, Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the score of one or more members in a sorted set.", Since: "6.2.0"},
+		{Name: "zcount", Handler: evalZCOUNT, Arity: 4, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the count of members in a sorted set that have scores within a range.", Since: "2.0.0"},
+		{Name: "zlexcount", Handler: evalZLEXCOUNT, Arity: 4, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the number of members in a sorted set within a lexicographical range.", Since: "2.8.9"},
+		{Name: "zrange", Handler: evalZRANGE, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a range of indexes.", Since: "1.2.0"},
+		{Name: "zrevrange", Handler: evalZREVRANGE, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a range of indexes in reverse order.", Since: "1.2.0"},
+		{Name: "zrangebyscore", Handler: evalZRANGEBYSCORE, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a range of scores.", Since: "1.0.5"},
+		{Name: "zrevrangebyscore", Handler: evalZREVRANGEBYSCORE, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a range of scores in reverse order.", Since: "2.2.0"},
+		{Name: "zrangebylex", Handler: evalZRANGEBYLEX, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a lexicographical range.", Since: "2.8.9"},
+		{Name: "zrevrangebylex", Handler: evalZREVRANGEBYLEX, Arity: -4, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns members in a sorted set within a lexicographical range in reverse order.", Since: "2.8.9"},
+		{Name: "zrangestore", Handler: evalZRANGESTORE, Arity: -5, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "sorted-set", Summary: "Stores a range of members from sorted set in a key.", Since: "6.2.0"},
+		{Name: "zrank", Handler: evalZRANK, Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the index of a member in a sorted set ordered by ascending scores.", Since: "2.0.0"},
+		{Name: "zrevrank", Handler: evalZREVRANK, Arity: -3, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the index of a member in a sorted set ordered by descending scores.", Since: "2.0.0"},
+		{Name: "zpopmin", Handler: evalZPOPMIN, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", Since: "5.0.0"},
+		{Name: "zpopmax", Handler: evalZPOPMAX, Arity: -2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.", Since: "5.0.0"},
+		{Name: "bzpopmin", Handler: evalBZPOPMIN, Arity: -3, Flags: CmdWrite | CmdFast | CmdBlocking,
+			FirstKey: 1, LastKey: -2, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.", Since: "5.0.0"},
+		{Name: "bzpopmax", Handler: evalBZPOPMAX, Arity: -3, Flags: CmdWrite | CmdFast | CmdBlocking,
+			FirstKey: 1, LastKey: -2, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise. Deletes the sorted set if the last element was popped.", Since: "5.0.0"},
+		{Name: "zunion", Handler: evalZUNION, Arity: -3, Flags: CmdReadonly, KeysFunc: numKeysPositions,
+			Group: "sorted-set", Summary: "Returns the union of multiple sorted sets.", Since: "6.2.0"},
+		{Name: "zinter", Handler: evalZINTER, Arity: -3, Flags: CmdReadonly, KeysFunc: numKeysPositions,
+			Group: "sorted-set", Summary: "Returns the intersect of multiple sorted sets.", Since: "6.2.0"},
+		{Name: "zdiff", Handler: evalZDIFF, Arity: -3, Flags: CmdReadonly, KeysFunc: numKeysPositions,
+			Group: "sorted-set", Summary: "Returns the difference between multiple sorted sets.", Since: "6.2.0"},
+		{Name: "zunionstore", Handler: evalZUNIONSTORE, Arity: -4, Flags: CmdWrite | CmdDenyOOM, KeysFunc: zstoreKeyPositions,
+			Group: "sorted-set", Summary: "Stores the union of multiple sorted sets in a key.", Since: "2.0.0"},
+		{Name: "zinterstore", Handler: evalZINTERSTORE, Arity: -4, Flags: CmdWrite | CmdDenyOOM, KeysFunc: zstoreKeyPositions,
+			Group: "sorted-set", Summary: "Stores the intersect of multiple sorted sets in a key.", Since: "2.0.0"},
+		{Name: "zdiffstore", Handler: evalZDIFFSTORE, Arity: -4, Flags: CmdWrite | CmdDenyOOM, KeysFunc: zstoreKeyPositions,
+			Group: "sorted-set", Summary: "Stores the difference of multiple sorted sets in a key.", Since: "6.2.0"},
+		{Name: "zremrangebyrank", Handler: evalZREMRANGEBYRANK, Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.", Since: "2.0.0"},
+		{Name: "zremrangebyscore", Handler: evalZREMRANGEBYSCORE, Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.", Since: "1.2.0"},
+		{Name: "zremrangebylex", Handler: evalZREMRANGEBYLEX, Arity: 4, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.", Since: "2.8.9"},
+		{Name: "zscan", Handler: evalZSCAN, Arity: -3, Flags: CmdReadonly,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "sorted-set", Summary: "Iterates over members and scores of a sorted set.", Since: "2.8.0"},
+		{Name: "waitkey", Handler: evalWAITKEY, Arity: -3, Flags: CmdReadonly | CmdBlocking,
+			FirstKey: 1, LastKey: -2, KeyStep: 1,
+			Group: "generic", Summary: "Blocks until one of the keys is written or the timeout is reached.", Since: "7.2.0"},
+		{Name: "ttl", Handler: evalTTL, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Returns the expiration time in seconds of a key.", Since: "1.0.0"},
+		{Name: "del", Handler: evalDEL, Arity: -2, Flags: CmdWrite,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "generic", Summary: "Deletes one or more keys.", Since: "1.0.0"},
+		{Name: "select", Handler: evalSELECT, Arity: 2, Flags: CmdLoading | CmdStale | CmdFast,
+			Group: "connection", Summary: "Changes the selected database.", Since: "1.0.0"},
+		{Name: "move", Handler: evalMOVE, Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Moves a key to another database.", Since: "1.0.0"},
+		{Name: "swapdb", Handler: evalSWAPDB, Arity: 3, Flags: CmdWrite | CmdFast,
+			Group: "server", Summary: "Swaps two Redis databases.", Since: "4.0.0"},
+		{Name: "flushdb", Handler: evalFLUSHDB, Arity: -1, Flags: CmdWrite,
+			Group: "server", Summary: "Removes all keys from the current database.", Since: "1.0.0"},
+		{Name: "flushall", Handler: evalFLUSHALL, Arity: -1, Flags: CmdWrite,
+			Group: "server", Summary: "Removes all keys from all databases.", Since: "1.0.0"},
+		{Name: "info", Handler: evalINFO, Arity: -1, Flags: CmdLoading | CmdStale,
+			Group: "server", Summary: "Returns information and statistics about the server.", Since: "1.0.0"},
+		{Name: "exists", Handler: evalEXISTS, Arity: -2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: -1, KeyStep: 1,
+			Group: "generic", Summary: "Determines whether one or more keys exist.", Since: "1.0.0"},
+		{Name: "keys", Handler: evalKEYS, Arity: 2, Flags: CmdReadonly,
+			Group: "generic", Summary: "Returns all key names that match a pattern.", Since: "1.0.0"},
+		{Name: "scan", Handler: evalSCAN, Arity: -2, Flags: CmdReadonly,
+			Group: "generic", Summary: "Iterates over the key names in the database.", Since: "2.8.0"},
+		{Name: "randomkey", Handler: evalRANDOMKEY, Arity: 1, Flags: CmdReadonly,
+			Group: "generic", Summary: "Returns a random key name from the database.", Since: "1.0.0"},
+		{Name: "dbsize", Handler: evalDBSIZE, Arity: 1, Flags: CmdReadonly | CmdFast,
+			Group: "server", Summary: "Returns the number of keys in the database.", Since: "1.0.0"},
+		{Name: "rename", Handler: evalRENAME, Arity: 3, Flags: CmdWrite,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "generic", Summary: "Renames a key and overwrites the destination.", Since: "1.0.0"},
+		{Name: "renamenx", Handler: evalRENAMENX, Arity: 3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "generic", Summary: "Renames a key only when the target key name doesn't exist.", Since: "1.0.0"},
+		{Name: "copy", Handler: evalCOPY, Arity: -3, Flags: CmdWrite | CmdDenyOOM,
+			FirstKey: 1, LastKey: 2, KeyStep: 1,
+			Group: "generic", Summary: "Copies the value of a key to a new key.", Since: "6.2.0"},
+		{Name: "expire", Handler: evalEXPIRE, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Sets the expiration time of a key in seconds.", Since: "1.0.0"},
+		{Name: "pexpire", Handler: evalPEXPIRE, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Sets the expiration time of a key in milliseconds.", Since: "2.6.0"},
+		{Name: "expireat", Handler: evalEXPIREAT, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Sets the expiration time of a key to a Unix timestamp.", Since: "1.2.0"},
+		{Name: "pexpireat", Handler: evalPEXPIREAT, Arity: -3, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", Since: "2.6.0"},
+		{Name: "pttl", Handler: evalPTTL, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Returns the expiration time in milliseconds of a key.", Since: "2.6.0"},
+		{Name: "expiretime", Handler: evalEXPIRETIME, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Returns the expiration time of a key as a Unix timestamp.", Since: "7.0.0"},
+		{Name: "pexpiretime", Handler: evalPEXPIRETIME, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.", Since: "7.0.0"},
+		{Name: "persist", Handler: evalPERSIST, Arity: 2, Flags: CmdWrite | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Removes the expiration time of a key.", Since: "2.2.0"},
+		{Name: "type", Handler: evalTYPE, Arity: 2, Flags: CmdReadonly | CmdFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1,
+			Group: "generic", Summary: "Determines the type of value stored at a key.", Since: "1.0.0"},
+		{Name: "object", Arity: -2, Flags: 0,
+			Group: "generic", Summary: "A container for object introspection commands.", Since: "2.2.3",
+			Subcommands: subcommands(
+				&Command{Name: "encoding", Handler: evalObjectENCODING, Arity: 3, Flags: CmdReadonly,
+					FirstKey: 2, LastKey: 2, KeyStep: 1,
+					Summary: "Returns the internal encoding of a Redis object.", Since: "2.2.3"},
+				&Command{Name: "idletime", Handler: evalObjectIDLETIME, Arity: 3, Flags: CmdReadonly,
+					FirstKey: 2, LastKey: 2, KeyStep: 1,
+					Summary: "Returns the time since the last access to a Redis object.", Since: "2.2.3"},
+				&Command{Name: "freq", Handler: evalObjectFREQ, Arity: 3, Flags: CmdReadonly,
+					FirstKey: 2, LastKey: 2, KeyStep: 1,
+					Summary: "Returns the logarithmic access frequency counter of a Redis object.", Since: "4.0.0"},
+				&Command{Name: "refcount", Handler: evalObjectREFCOUNT, Arity: 3, Flags: CmdReadonly,
+					FirstKey: 2, LastKey: 2, KeyStep: 1,
+					Summary: "Returns the reference count of a value of a key.", Since: "2.2.3"},
+				&Command{Name: "help", Handler: evalObjectHELP, Arity: 2, Flags: CmdLoading | CmdStale,
+					Summary: "Returns helpful text about the different subcommands.", Since: "6.2.0"},
+			)},
+		{Name: "memory", Arity: -2, Flags: 0,
+			Group: "server", Summary: "A container for memory diagnostics commands.", Since: "4.0.0",
+			Subcommands: subcommands(
+				&Command{Name: "usage", Handler: evalMemoryUSAGE, Arity: -3, Flags: CmdReadonly,
+					FirstKey: 2, LastKey: 2, KeyStep: 1,
+					Summary: "Estimates the memory usage of a key.", Since: "4.0.0"},
+			)},
+		{Name: "hello", Handler: evalHELLO, Arity: -1, Flags: CmdNoScript | CmdLoading | CmdStale | CmdFast | CmdNoAuth,
+			Group: "connection", Summary: "Handshakes with the Redis server.", Since: "6.0.0"},
+		{Name: "client", Arity: -2, Flags: CmdNoScript | CmdLoading | CmdStale,
+			Group: "connection", Summary: "A container for client connection commands.", Since: "2.4.0",
+			Subcommands: subcommands(
+				&Command{Name: "list", Handler: evalClientLIST, Arity: -2, Flags: CmdAdmin | CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Lists open connections.", Since: "2.4.0"},
+				&Command{Name: "info", Handler: evalClientINFO, Arity: 2, Flags: CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Returns information about the connection.", Since: "6.2.0"},
+				&Command{Name: "id", Handler: evalClientID, Arity: 2, Flags: CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Returns the unique client ID of the connection.", Since: "5.0.0"},
+				&Command{Name: "setname", Handler: evalClientSETNAME, Arity: 3, Flags: CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Sets the connection name.", Since: "2.6.9"},
+				&Command{Name: "getname", Handler: evalClientGETNAME, Arity: 2, Flags: CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Returns the name of the connection.", Since: "2.6.9"},
+				&Command{Name: "kill", Handler: evalClientKILL, Arity: -3, Flags: CmdAdmin | CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Terminates open connections.", Since: "2.4.0"},
+				&Command{Name: "unblock", Handler: evalClientUNBLOCK, Arity: -3, Flags: CmdAdmin | CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Unblocks a client blocked by a blocking command from a different connection.", Since: "5.0.0"},
+				&Command{Name: "no-evict", Handler: evalClientNOEVICT, Arity: 3, Flags: CmdAdmin | CmdNoScript | CmdLoading | CmdStale,
+					Summary: "Sets the client eviction mode of the connection.", Since: "7.0.0"},
+			)},
+		{Name: "command", Handler: evalCOMMAND, Arity: -1, Flags: CmdLoading | CmdStale,
+			Group: "server", Summary: "Returns detailed information about all commands.", Since: "2.8.13",
+			Subcommands: subcommands(
+				&Command{Name: "count", Handler: evalCommandCOUNT, Arity: 2, Flags: CmdLoading | CmdStale,
+					Summary: "Returns a count of commands.", Since: "2.8.13"},
+				&Command{Name: "info", Handler: evalCommandINFO, Arity: -2, Flags: CmdLoading | CmdStale,
+					Summary: "Returns information about one, multiple or all commands.", Since: "2.8.13"},
+				&Command{Name: "docs", Handler: evalCommandDOCS, Arity: -2, Flags: CmdLoading | CmdStale,
+					Summary: "Returns documentary information about one, multiple or all commands.", Since: "7.0.0"},
+				&Command{Name: "getkeys", Handler: evalCommandGETKEYS, Arity: -3, Flags: CmdLoading | CmdStale,
+					Summary: "Extracts the key names from an arbitrary command.", Since: "2.8.13"},
+			)},
+	} {
+		commandTable[strings.ToUpper(cmd.Name)] = cmd
+		for _, sub := range cmd.Subcommands {
+			sub.parent = cmd
+			sub.Group = cmd.Group
+		}
+	}
+}
+
+func subcommands(cmds ...*Command) map[string]*Command {
+	table := make(map[string]*Command)
+	for _, cmd := range cmds {
+		table[strings.ToUpper(cmd.Name)] = cmd
+	}
+	return table
+}
+
+// LookupCommand returns the table entry for name (any case), or nil
+func LookupCommand(name string) *Command {
+	return commandTable[strings.ToUpper(name)]
+}
+
+// FullName is the name Redis reports, "client|list" for subcommands
+func (cmd *Command) FullName() string {
+	if cmd.parent != nil {
+		return cmd.parent.Name + "|" + cmd.Name
+	}
+	return cmd.Name
+}
+
+func (cmd *Command) arityOK(argc int) bool {
+	return (cmd.Arity > 0 && argc == cmd.Arity) || (cmd.Arity < 0 && argc >= -cmd.Arity)
+}
+
+func arityError(cmd *Command) []byte {
+	return []byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", cmd.FullName()))
+}
+
+func unknownCommandError(name string, Args []string) []byte {
+	var sb strings.Builder
+	for _, arg := range Args {
+		if sb.Len() >= 128 {
+			break
+		}
+		if len(arg) > 128 {
+			arg = arg[:128]
+		}
+		sb.WriteString(fmt.Sprintf("'%s' ", arg))
+	}
+	return []byte(fmt.Sprintf("-ERR unknown command '%s', with args beginning with: %s\r\n", name, sb.String()))
+}
+
+// call checks the arity, resolves the subcommand if any and runs the handler
+func (cmd *Command) call(Args []string, c *Client) []byte {
+	if cmd.Subcommands != nil && len(Args) > 0 {
+		sub, ok := cmd.Subcommands[strings.ToUpper(Args[0])]
+		if !ok {
+			return []byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try %s HELP.\r\n", Args[0], strings.ToUpper(cmd.Name)))
+		}
+		c.LastCmd = sub.FullName()
+		if !sub.arityOK(len(Args) + 1) {
+			return arityError(sub)
+		}
+		if sub.deniedOOM(Args) {
+			return RESP_OOM
+		}
+		return sub.Handler(Args[1:], c)
+	}
+	if !cmd.arityOK(len(Args)+1) || cmd.Handler == nil {
+		return arityError(cmd)
+	}
+	if cmd.deniedOOM(Args) {
+		return RESP_OOM
+	}
+	reply := cmd.Handler(Args, c)
+	// A write that went through may release clients blocked on its keys
+	if cmd.Flags&CmdWrite != 0 && len(db.blockingKeys) > 0 && len(reply) > 0 && reply[0] != '-' {
+		argv := append([]string{cmd.Name}, Args...)
+		for _, pos := range cmd.keyPositions(argv) {
+			signalKeyAsReady(argv[pos])
+		}
+	}
+	return reply
+}
+
+// deniedOOM runs the evictions due before the command and reports whether
+// it must be refused because the keys limit leaves no room for the keys it
+// may add, with nothing left to evict. Like in Redis this comes after the
+// arity checks, so a malformed command still gets its syntax error. Args
+// are the arguments after the command name, the subcommand name
+// included, as key positions count from the start of the command line.
+func (cmd *Command) deniedOOM(Args []string) bool {
+	if cmd.Flags&CmdDenyOOM == 0 {
+		performEvictions(0)
+		return false
+	}
+	return !performEvictions(cmd.newKeys(Args))
+}
+
+// newKeys counts the distinct keys of the command that do not exist yet,
+// the most it can add. The keys are only looked at near the keys limit,
+// since a command never adds more keys than it has arguments.
+func (cmd *Command) newKeys(Args []string) int {
+	if storeConfig == nil || totalKeys()+len(Args) <= storeConfig.KeysLimit {
+		return 0
+	}
+	argv := append([]string{cmd.Name}, Args...)
+	seen := make(map[string]bool)
+	for _, pos := range cmd.keyPositions(argv) {
+		key := argv[pos]
+		if !seen[key] && Peek(key) == nil {
+			seen[key] = true
+		}
+	}
+	return len(seen)
+}
+
+// keyPositions returns the argv indexes holding keys
+func (cmd *Command) keyPositions(argv []string) []int {
+	if cmd.KeysFunc != nil {
+		return cmd.KeysFunc(argv)
+	}
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(argv) + last
+	}
+	var positions []int
+	for i := cmd.FirstKey; i <= last && i < len(argv); i += cmd.KeyStep {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// aclCategories derives the ACL categories COMMAND shows from the flags and group
+func (cmd *Command) aclCategories() []interface{} {
+	var cats []interface{}
+	if cmd.Flags&CmdWrite != 0 {
+		cats = append(cats, SimpleString("@write"))
+	}
+	if cmd.Flags&CmdReadonly != 0 {
+		cats = append(cats, SimpleString("@read"))
+	}
+	if cmd.Flags&CmdAdmin != 0 {
+		cats = append(cats, SimpleString("@admin"), SimpleString("@dangerous"))
+	}
+	if cmd.Flags&CmdBlocking != 0 {
+		cats = append(cats, SimpleString("@blocking"))
+	}
+	if cmd.Flags&CmdFast != 0 {
+		cats = append(cats, SimpleString("@fast"))
+	} else {
+		cats = append(cats, SimpleString("@slow"))
+	}
+	switch cmd.Group {
+	case "generic":
+		cats = append(cats, SimpleString("@keyspace"))
+	case "string", "list", "hash", "set", "connection":
+		cats = append(cats, SimpleString("@"+cmd.Group))
+	case "sorted-set":
+		cats = append(cats, SimpleString("@sortedset"))
+	}
+	return cats
+}
+
+func sortedNames(table map[string]*Command) []string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// info builds the 10 element COMMAND / COMMAND INFO entry
+func (cmd *Command) info() []interface{} {
+	flags := RespSet{}
+	for _, f := range flagNames {
+		if cmd.Flags&f.flag != 0 {
+			flags = append(flags, SimpleString(f.name))
+		}
+	}
+	subs := []interface{}{}
+	for _, name := range sortedNames(cmd.Subcommands) {
+		subs = append(subs, cmd.Subcommands[name].info())
+	}
+	return []interface{}{
+		cmd.FullName(),
+		cmd.Arity,
+		flags,
+		cmd.FirstKey,
+		cmd.LastKey,
+		cmd.KeyStep,
+		RespSet(cmd.aclCategories()),
+		[]interface{}{}, // tips
+		[]interface{}{}, // key specs
+		subs,
+	}
+}
+
+// docs builds the COMMAND DOCS map of one command
+func (cmd *Command) docs() RespMap {
+	doc := RespMap{
+		"summary", cmd.Summary,
+		"since", cmd.Since,
+		"group", cmd.Group,
+	}
+	if len(cmd.Subcommands) > 0 {
+		subs := RespMap{}
+		for _, name := range sortedNames(cmd.Subcommands) {
+			sub := cmd.Subcommands[name]
+			subs = append(subs, sub.FullName(), sub.docs())
+		}
+		doc = append(doc, "subcommands", subs)
+	}
+	return doc
+}
+
+// COMMAND
+func evalCOMMAND(Args []string, c *Client) []byte {
+	reply := []interface{}{}
+	for _, name := range sortedNames(commandTable) {
+		reply = append(reply, commandTable[name].info())
+	}
+	return c.addReply(reply)
+}
+
+// COMMAND COUNT
+func evalCommandCOUNT(Args []string, c *Client) []byte {
+	return c.addReply(len(commandTable))
+}
+
+// lookupCommandPath finds "get" as well as "client|list"
+func lookupCommandPath(name string) *Command {
+	parts := strings.SplitN(name, "|", 2)
+	cmd := LookupCommand(parts[0])
+	if cmd == nil || len(parts) == 1 {
+		return cmd
+	}
+	return cmd.Subcommands[strings.ToUpper(parts[1])]
+}
+
+// COMMAND INFO [command-name ...]
+func evalCommandINFO(Args []string, c *Client) []byte {
+	if len(Args) == 0 {
+		return evalCOMMAND(Args, c)
+	}
+	reply := []interface{}{}
+	for _, name := range Args {
+		if cmd := lookupCommandPath(name); cmd != nil {
+			reply = append(reply, cmd.info())
+		} else {
+			reply = append(reply, NullArray{})
+		}
+	}
+	return c.addReply(reply)
+}
+
+// COMMAND DOCS [command-name ...], unknown names are skipped
+func evalCommandDOCS(Args []string, c *Client) []byte {
+	reply := RespMap{}
+	if len(Args) == 0 {
+		for _, name := range sortedNames(commandTable) {
+			cmd := commandTable[name]
+			reply = append(reply, cmd.Name, cmd.docs())
+		}
+	}
+	for _, name := range Args {
+		if cmd := lookupCommandPath(name); cmd != nil {
+			reply = append(reply, cmd.FullName(), cmd.docs())
+		}
+	}
+	return c.addReply(reply)
+}
+
+// COMMAND GETKEYS command [arg ...]
+func evalCommandGETKEYS(Args []string, c *Client) []byte {
+	cmd := LookupCommand(Args[0])
+	if cmd == nil {
+		return []byte("-ERR Invalid command specified\r\n")
+	}
+	if cmd.Subcommands != nil && len(Args) > 1 {
+		if sub, ok := cmd.Subcommands[strings.ToUpper(Args[1])]; ok {
+			cmd = sub
+		}
+	}
+	if !cmd.arityOK(len(Args)) {
+		return []byte("-ERR Invalid number of arguments specified for command\r\n")
+	}
+	positions := cmd.keyPositions(Args)
+	if len(positions) == 0 {
+		return []byte("-ERR The command has no key arguments\r\n")
+	}
+	keys := make([]string, len(positions))
+	for i, pos := range positions {
+		keys[i] = Args[pos]
+	}
+	return c.addReply(keys)
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// SimpleString is sent as a RESP simple string (+OK) rather than a bulk
+// string. It must not contain CR or LF.
+type SimpleString string
+
+// NullArray is the null array reply (*-1 in RESP2), as opposed to nil which
+// is sent as the null bulk string ($-1).
+type NullArray struct{}
+
+// Reply types that only exist in RESP3. When the client is still on RESP2
+// each of them is sent as the closest RESP2 shape instead, the same way
+// Redis downgrades its replies.
+
+// RespMap is a map reply stored as alternating keys and values so the
+// order we reply in is stable. RESP2 sees a flat array.
+type RespMap []interface{}
+
+// RespSet is an unordered collection reply. RESP2 sees an array.
+type RespSet []interface{}
+
+// RespPush is an out-of-band push message. RESP2 sees an array.
+type RespPush []interface{}
+
+// BigNumber holds the decimal digits of an integer too large for int64.
+// RESP2 sees a bulk string.
+type BigNumber string
+
+// VerbatimString is text with a three letter format hint such as "txt" or
+// "mkd". RESP2 sees a bulk string of just the text.
+type VerbatimString struct {
+	Format string
+	Text   string
+}
+
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	// Plain notation for the usual magnitudes so a score like 1700000000
+	// does not come back as 1.7e+09; exponents only at the extremes
+	if abs := math.Abs(f); abs == 0 || (abs >= 1e-6 && abs < 1e21) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// appendHeader appends a type byte followed by a length or value and CRLF
+func appendHeader(dst []byte, prefix byte, n int64) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
+
+func appendLine(dst []byte, prefix byte, s string) []byte {
+	dst = append(dst, prefix)
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendBulk(dst []byte, s string) []byte {
+	dst = appendHeader(dst, '$', int64(len(s)))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendAggregate(dst []byte, prefix byte, elems []interface{}, proto int) []byte {
+	dst = appendHeader(dst, prefix, int64(len(elems)))
+	for _, elem := range elems {
+		dst = AppendValue(dst, elem, proto)
+	}
+	return dst
+}
+
+// AppendValue appends the encoding of value for a client speaking RESP
+// version proto to dst and returns the extended slice. Passing a reused
+// buffer avoids allocating for every reply.
+//
+// Strings and []byte become bulk strings, integers become RESP integers,
+// nil is the null bulk string, and an error is sent as an error reply with
+// its message (which should start with a code such as ERR or WRONGTYPE).
+// Slices are encoded element by element, so arrays nest freely. Any other
+// type is a bug in the handler and panics.
+func AppendValue(dst []byte, value interface{}, proto int) []byte {
+	switch v := value.(type) {
+	case nil:
+		if proto == 3 {
+			return append(dst, "_\r\n"...)
+		}
+		return append(dst, "$-1\r\n"...)
+	case NullArray:
+		if proto == 3 {
+			return append(dst, "_\r\n"...)
+		}
+		return append(dst, "*-1\r\n"...)
+	case SimpleString:
+		return appendLine(dst, '+', string(v))
+	case string:
+		return appendBulk(dst, v)
+	case []byte:
+		dst = appendHeader(dst, '$', int64(len(v)))
+		dst = append(dst, v...)
+		return append(dst, '\r', '\n')
+	case error:
+		return appendLine(dst, '-', v.Error())
+	case int:
+		return appendHeader(dst, ':', int64(v))
+	case int8:
+		return appendHeader(dst, ':', int64(v))
+	case int16:
+		return appendHeader(dst, ':', int64(v))
+	case int32:
+		return appendHeader(dst, ':', int64(v))
+	case int64:
+		return appendHeader(dst, ':', v)
+	case uint8:
+		return appendHeader(dst, ':', int64(v))
+	case uint16:
+		return appendHeader(dst, ':', int64(v))
+	case uint32:
+		return appendHeader(dst, ':', int64(v))
+	case bool:
+		if proto == 3 {
+			if v {
+				return append(dst, "#t\r\n"...)
+			}
+			return append(dst, "#f\r\n"...)
+		}
+		if v {
+			return append(dst, ":1\r\n"...)
+		}
+		return append(dst, ":0\r\n"...)
+	case float64:
+		if proto == 3 {
+			return appendLine(dst, ',', formatDouble(v))
+		}
+		return appendBulk(dst, formatDouble(v))
+	case BigNumber:
+		if proto == 3 {
+			return appendLine(dst, '(', string(v))
+		}
+		return appendBulk(dst, string(v))
+	case VerbatimString:
+		if proto == 3 {
+			dst = appendHeader(dst, '=', int64(len(v.Text)+4))
+			dst = append(dst, v.Format...)
+			dst = append(dst, ':')
+			dst = append(dst, v.Text...)
+			return append(dst, '\r', '\n')
+		}
+		return appendBulk(dst, v.Text)
+	case []string:
+		dst = appendHeader(dst, '*', int64(len(v)))
+		for _, s := range v {
+			dst = appendBulk(dst, s)
+		}
+		return dst
+	case []int64:
+		dst = appendHeader(dst, '*', int64(len(v)))
+		for _, n := range v {
+			dst = appendHeader(dst, ':', n)
+		}
+		return dst
+	case []interface{}:
+		return appendAggregate(dst, '*', v, proto)
+	case RespMap:
+		if proto == 3 {
+			dst = appendHeader(dst, '%', int64(len(v)/2))
+			for _, elem := range v {
+				dst = AppendValue(dst, elem, proto)
+			}
+			return dst
+		}
+		return appendAggregate(dst, '*', v, proto)
+	case RespSet:
+		if proto == 3 {
+			return appendAggregate(dst, '~', v, proto)
+		}
+		return appendAggregate(dst, '*', v, proto)
+	case RespPush:
+		if proto == 3 {
+			return appendAggregate(dst, '>', v, proto)
+		}
+		return appendAggregate(dst, '*', v, proto)
+	}
+	// Queuing nothing would leave the client waiting for a reply forever
+	panic(fmt.Sprintf("AppendValue: no RESP encoding for %T", value))
+}
+
+// addReply appends the encoding of value to the client's output buffer and
+// returns the bytes it added. Handlers return that slice as their reply;
+// EvalAndResponse recognises it as already queued and does not copy it.
+func (c *Client) addReply(value interface{}) []byte {
+	start := len(c.Out)
+	c.Out = AppendValue(c.Out, value, c.Proto)
+	return c.Out[start:len(c.Out):len(c.Out)]
+}
+
+func Encode(value interface{}, isSimple bool) []byte {
+	return EncodeProto(value, isSimple, 2)
+}
+
+// EncodeProto encodes value for a client speaking RESP version proto.
+// isSimple sends a top level string as a simple string.
+func EncodeProto(value interface{}, isSimple bool, proto int) []byte {
+	// fmt.Printf("Encoding value: %v, isSimple: %t\n", value, isSimple)
+	if s, ok := value.(string); ok && isSimple {
+		value = SimpleString(s)
+	}
+	return AppendValue(nil, value, proto)
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// sprintfEncode is the fmt.Sprintf based Encode that AppendValue replaced,
+// kept here as the baseline for the benchmarks
+func sprintfEncode(value interface{}, isSimple bool) []byte {
+	switch v := value.(type) {
+	case string:
+		if isSimple {
+			return []byte(fmt.Sprintf("+%s\r\n", v))
+		}
+		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+	case int, int8, int16, int32, int64:
+		return []byte(fmt.Sprintf(":%d\r\n", v))
+	}
+	return []byte{}
+}
+
+// benchReplies are the reply shapes the old encoder could produce
+var benchReplies = []struct {
+	value    interface{}
+	isSimple bool
+}{
+	{"OK", true},
+	{"hello world", false},
+	{int64(1234567890), false},
+}
+
+func TestEncodeMatchesSprintf(t *testing.T) {
+	for _, r := range benchReplies {
+		got, want := string(Encode(r.value, r.isSimple)), string(sprintfEncode(r.value, r.isSimple))
+		if got != want {
+			t.Errorf("Encode(%v, %v) = %q, want %q", r.value, r.isSimple, got, want)
+		}
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"null bulk", nil, "$-1\r\n"},
+		{"null array", NullArray{}, "*-1\r\n"},
+		{"simple string", SimpleString("OK"), "+OK\r\n"},
+		{"empty bulk", "", "$0\r\n\r\n"},
+		{"bytes", []byte("a\r\nb"), "$4\r\na\r\nb\r\n"},
+		{"error", errors.New("WRONGTYPE bad"), "-WRONGTYPE bad\r\n"},
+		{"int", -7, ":-7\r\n"},
+		{"uint8", uint8(255), ":255\r\n"},
+		{"empty array", []interface{}{}, "*0\r\n"},
+		{"empty string array", []string{}, "*0\r\n"},
+		{"string array", []string{"a", "bc"}, "*2\r\n$1\r\na\r\n$2\r\nbc\r\n"},
+		{"int64 array", []int64{1, -2}, "*2\r\n:1\r\n:-2\r\n"},
+		{"mixed array", []interface{}{"a", int64(1), SimpleString("OK"), errors.New("ERR x"), []byte("b")},
+			"*5\r\n$1\r\na\r\n:1\r\n+OK\r\n-ERR x\r\n$1\r\nb\r\n"},
+		{"nil element", []interface{}{"a", nil, "b"}, "*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n"},
+		{"null array element", []interface{}{NullArray{}}, "*1\r\n*-1\r\n"},
+		{"nested arrays", []interface{}{[]interface{}{"a", []string{"b"}}, []interface{}{}, []int64{3}},
+			"*3\r\n*2\r\n$1\r\na\r\n*1\r\n$1\r\nb\r\n*0\r\n*1\r\n:3\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendValue(nil, tt.value, 2)); got != tt.want {
+				t.Errorf("AppendValue(%#v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendValueUnknownTypePanics(t *testing.T) {
+	for _, value := range []interface{}{uint64(5), float32(1), []float64{1}, map[string]string{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("AppendValue(%#v) did not panic", value)
+				}
+			}()
+			AppendValue(nil, value, 2)
+		}()
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, r := range benchReplies {
+				sprintfEncode(r.value, r.isSimple)
+			}
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, r := range benchReplies {
+				Encode(r.value, r.isSimple)
+			}
+		}
+	})
+}
+
+// BenchmarkAppendValue encodes into one reused buffer, the way replies are
+// queued in a client's output buffer
+func BenchmarkAppendValue(b *testing.B) {
+	b.ReportAllocs()
+	values := []interface{}{SimpleString("OK"), "hello world", int64(1234567890)}
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		for _, v := range values {
+			buf = AppendValue(buf, v, 2)
+		}
+	}
+}
+
+func TestAppendValueProtocols(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		resp2 string
+		resp3 string
+	}{
+		{"null bulk", nil, "$-1\r\n", "_\r\n"},
+		{"null array", NullArray{}, "*-1\r\n", "_\r\n"},
+		{"true", true, ":1\r\n", "#t\r\n"},
+		{"false", false, ":0\r\n", "#f\r\n"},
+		{"double", 1.5, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{"large double", 1700000000.0, "$10\r\n1700000000\r\n", ",1700000000\r\n"},
+		{"infinite double", math.Inf(-1), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"big number", BigNumber("12345678901234567890"), "$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{"verbatim string", VerbatimString{"txt", "hi"}, "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{"map counts pairs", RespMap{"a", int64(1), "b", nil},
+			"*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n$-1\r\n", "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n_\r\n"},
+		{"empty map", RespMap{}, "*0\r\n", "%0\r\n"},
+		{"set", RespSet{"a", "b"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"push", RespPush{"message", "x"}, "*2\r\n$7\r\nmessage\r\n$1\r\nx\r\n", ">2\r\n$7\r\nmessage\r\n$1\r\nx\r\n"},
+		{"nested", []interface{}{RespMap{"k", 0.5}, RespSet{}},
+			"*2\r\n*2\r\n$1\r\nk\r\n$3\r\n0.5\r\n*0\r\n", "*2\r\n%1\r\n$1\r\nk\r\n,0.5\r\n~0\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendValue(nil, tt.value, 2)); got != tt.resp2 {
+				t.Errorf("RESP2: got %q, want %q", got, tt.resp2)
+			}
+			if got := string(AppendValue(nil, tt.value, 3)); got != tt.resp3 {
+				t.Errorf("RESP3: got %q, want %q", got, tt.resp3)
+			}
+		})
+	}
+}
+
+func TestHELLO(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmd       string
+		want      string // the whole reply for errors, its first line otherwise
+		wantProto int
+		wantName  string
+	}{
+		{"no version keeps the protocol", "HELLO", "*14\r\n", 2, ""},
+		{"switch to RESP3", "HELLO 3", "%7\r\n", 3, ""},
+		{"stay on RESP2", "HELLO 2", "*14\r\n", 2, ""},
+		{"unsupported version", "HELLO 4", "-NOPROTO unsupported protocol version\r\n", 2, ""},
+		{"version not a number", "HELLO three", "-ERR Protocol version is not an integer or out of range\r\n", 2, ""},
+		{"auth as the default user", "HELLO 3 AUTH default secret", "%7\r\n", 3, ""},
+		{"auth as another user", "HELLO 3 AUTH bob secret", "-WRONGPASS invalid username-password pair or user is disabled.\r\n", 2, ""},
+		{"auth without a password", "HELLO 3 AUTH default", "-ERR Syntax error in HELLO option 'AUTH'\r\n", 2, ""},
+		{"setname", "HELLO 3 SETNAME conn1", "%7\r\n", 3, "conn1"},
+		{"setname without a name", "HELLO 3 SETNAME", "-ERR Syntax error in HELLO option 'SETNAME'\r\n", 2, ""},
+		{"setname with a control character", "HELLO 3 SETNAME a\x01b", "-ERR Client names cannot contain spaces, newlines or special characters.\r\n", 2, ""},
+		{"nothing applied when a later option fails", "HELLO 3 SETNAME conn1 AUTH bob secret", "-WRONGPASS invalid username-password pair or user is disabled.\r\n", 2, ""},
+		{"unknown option", "HELLO 3 FOO", "-ERR Syntax error in HELLO option 'FOO'\r\n", 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			c := NewClient(-1, "", "")
+			got := run(c, tt.cmd)
+			if tt.want[0] == '-' && got != tt.want || !strings.HasPrefix(got, tt.want) {
+				t.Errorf("%s replied %q, want %q", tt.cmd, got, tt.want)
+			}
+			if c.Proto != tt.wantProto || c.Name != tt.wantName {
+				t.Errorf("client has proto %d and name %q, want %d and %q", c.Proto, c.Name, tt.wantProto, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestHELLOReply(t *testing.T) {
+	resetServer(t)
+	c := NewClient(-1, "", "")
+	want := fmt.Sprintf("%%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.2.0\r\n"+
+		"$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:%d\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n"+
+		"$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n", c.ID)
+	if got := run(c, "HELLO 3"); got != want {
+		t.Errorf("HELLO 3 replied %q, want %q", got, want)
+	}
+}
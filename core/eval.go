@@ -3,12 +3,26 @@ package core
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
 /* implement OK and NIL */
 var RESP_OK []byte = []byte("+OK\r\n")
 var RESP_NIL []byte = []byte("$-1\r\n")
+var RESP_WRONGTYPE []byte = []byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+var RESP_OOM []byte = []byte("-OOM command not allowed when used memory > 'maxmemory'\r\n")
+
+// getTyped looks key up for a command that works on objType values. It
+// returns nil for a missing key, and the WRONGTYPE reply when the key holds
+// another type; every typed command goes through here.
+func getTyped(key string, objType uint8) (*Obj, []byte) {
+	obj := Get(key)
+	if obj != nil && obj.Type != objType {
+		return nil, RESP_WRONGTYPE
+	}
+	return obj, nil
+}
 
 // RedisCmd represents a parsed Redis command
 type RedisCmd struct {
@@ -16,55 +30,8 @@ type RedisCmd struct {
 	Args []string
 }
 
-func Encode(value interface{}, isSimple bool) []byte {
-	// fmt.Printf("Encoding value: %v, isSimple: %t\n", value, isSimple)
-	switch v := value.(type) {
-	case string:
-		if isSimple {
-			result := []byte(fmt.Sprintf("+%s\r\n", v))
-			// fmt.Printf("Encoded as Simple String: %q\n", string(result))
-			return result
-		}
-		result := []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
-		// fmt.Printf("Encoded as Bulk String: %q\n", string(result))
-		return result
-	case int, int8, int16, int32, int64:
-		result := []byte(fmt.Sprintf(":%d\r\n", v))
-		return result
-	}
-	// fmt.Println("Unknown type, returning empty")
-	return []byte{}
-}
-func evalEXPIRE(Args []string) []byte {
-	//EXPIRE key time in sec
-	if len(Args) != 2 {
-		return []byte("-ERR wrong number of arguments for 'expire' command\r\n")
-	}
-	//Get the key,timeout duration
-	var key string = Args[0]
-	expireDurationSec, err := strconv.ParseInt(Args[1], 10, 64)
-	if err != nil {
-		return []byte("-ERR value is not an integer or out of range\r\n")
-	}
-
-	//get the key
-	obj := Get(key)
-	if obj == nil {
-		//return 0 if key is invalid
-		return Encode(0, false)
-	}
-	obj.ExpiresAt = time.Now().UnixMilli() + expireDurationSec*1000 //store in mili second
-
-	//return 1 success
-	return Encode(1, false)
-
-}
-func evalDEL(Args []string) []byte {
+func evalDEL(Args []string, c *Client) []byte {
 	//DEL k1,k2,..
-	if len(Args) < 1 {
-		return []byte("-ERR wrong number of arguments for 'del' command\r\n")
-
-	}
 	var del_cnt int = 0
 	for i := 0; i < len(Args); i++ {
 		key := Args[i]
@@ -72,143 +39,139 @@ func evalDEL(Args []string) []byte {
 			del_cnt++
 		}
 	}
-	return Encode(del_cnt, false)
+	return c.addReply(del_cnt)
 }
-func evalTTL(Args []string) []byte {
-	if len(Args) != 1 {
-		return []byte("-ERR wrong number of arguments for 'TTL' command\r\n")
-	}
-	var key string = Args[0]
-
-	obj := Get(key)
-
-	if obj == nil {
-		return []byte(":-2\r\n")
-	}
-	if obj.ExpiresAt == -1 {
-		return []byte(":-1\r\n")
-	}
-
-	durationMS := obj.ExpiresAt - time.Now().UnixMilli()
+func evalTIME(Args []string, c *Client) []byte {
+	now := time.Now()
+	seconds := now.Unix()
+	microseconds := now.Nanosecond() / 1000
 
-	if durationMS < 0 {
-		return []byte(":-2\r\n")
+	// TIME command returns an array with 2 elements: [seconds, microseconds]
+	// as bulk strings, in both RESP2 and RESP3
+	reply := []interface{}{
+		strconv.FormatInt(seconds, 10),
+		strconv.Itoa(microseconds),
 	}
-	return Encode(durationMS/1000, false)
+	return c.addReply(reply)
 }
-func evalGET(Args []string) []byte {
-	if len(Args) != 1 {
-		return []byte("-ERR wrong number of arguments for 'GET' command\r\n")
-	}
-	var key string = Args[0]
 
-	//Get the object
-	obj := Get(key)
-	// key not exist
-
-	if obj == nil {
-		return RESP_NIL
+// validClientName reports whether name only has printable characters and no
+// spaces, so it can be shown in CLIENT LIST output
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
 	}
-	return Encode(obj.Value, false)
+	return true
 }
-func evalSET(Args []string) []byte {
-	//check the size
-	if len(Args) <= 1 {
-		return []byte("-ERR wrong number of arguments for 'SET' command\r\n")
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// switches the connection's protocol and replies with server details.
+func evalHELLO(Args []string, c *Client) []byte {
+	proto := c.Proto
+	if len(Args) > 0 {
+		ver, err := strconv.ParseInt(Args[0], 10, 64)
+		if err != nil {
+			return []byte("-ERR Protocol version is not an integer or out of range\r\n")
+		}
+		if ver != 2 && ver != 3 {
+			return []byte("-NOPROTO unsupported protocol version\r\n")
+		}
+		proto = int(ver)
 	}
 
-	key, value := Args[0], Args[1]
-	var exDurationMs int64 = -1
-	for i := 2; i < len(Args); i++ {
-		switch Args[i] {
-		case "EX", "ex":
-			i++
-			if i == len(Args) {
-				// means no argument provided for EX time
-				return []byte("-ERR syntax error\r\n")
+	name, setName := "", false
+	for i := 1; i < len(Args); i++ {
+		switch strings.ToUpper(Args[i]) {
+		case "AUTH":
+			if i+2 >= len(Args) {
+				return []byte(fmt.Sprintf("-ERR Syntax error in HELLO option '%s'\r\n", Args[i]))
+			}
+			// There is no ACL yet, only the default user without a password
+			if Args[i+1] != "default" {
+				return []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
 			}
-			//calculate the expiration time and change to ms
-			exSeconds, err := strconv.ParseInt(Args[i], 10, 64)
-			if err != nil {
-				return []byte("-ERR value is not an integer or out of range\r\n")
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(Args) {
+				return []byte(fmt.Sprintf("-ERR Syntax error in HELLO option '%s'\r\n", Args[i]))
+			}
+			i++
+			if !validClientName(Args[i]) {
+				return []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
 			}
-			exDurationMs = exSeconds * 1000 //convert to ms
+			name, setName = Args[i], true
 		default:
-			return []byte(fmt.Sprintf("-ERR unknown Argument '%s'\r\n", Args[i]))
-			//store the k,v pair
+			return []byte(fmt.Sprintf("-ERR Syntax error in HELLO option '%s'\r\n", Args[i]))
 		}
 	}
-	Put(key, NewObj(value, exDurationMs))
-	return RESP_OK
 
-}
-func evalTIME(Args []string) []byte {
-	if len(Args) > 0 {
-		return []byte("-ERR wrong number of arguments for 'TIME' command\r\n")
+	// Only apply the changes once the whole command has been validated
+	c.Proto = proto
+	if setName {
+		c.Name = name
 	}
 
-	now := time.Now()
-	seconds := now.Unix()
-	microseconds := now.Nanosecond() / 1000
-
-	// TIME command returns an array with 2 elements: [seconds, microseconds]
-	// Format: *2\r\n$len\r\nseconds\r\n$len\r\nmicroseconds\r\n
-	secondsStr := fmt.Sprintf("%d", seconds)
-	microsecondsStr := fmt.Sprintf("%d", microseconds)
-
-	result := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
-		len(secondsStr), secondsStr,
-		len(microsecondsStr), microsecondsStr)
-
-	return []byte(result)
+	reply := RespMap{
+		"server", "redis",
+		"version", "7.2.0",
+		"proto", c.Proto,
+		"id", c.ID,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []interface{}{},
+	}
+	return c.addReply(reply)
 }
-func evalECHO(Args []string) []byte {
-	if len(Args) != 1 {
-		return []byte("-ERR wrong number of arguments for 'echo' command\r\n")
-	} else {
-		return Encode(Args[0], false)
+
+// TYPE key
+func evalTYPE(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(SimpleString("none"))
 	}
+	return c.addReply(SimpleString(obj.TypeName()))
 }
 
-func evalPING(Args []string) []byte {
-	// fmt.Printf("Evaluating PING command with %d args: %v\n", len(Args), Args)
+func evalECHO(Args []string, c *Client) []byte {
+	return c.addReply(Args[0])
+}
 
-	if len(Args) >= 2 {
-		// fmt.Println("Too many arguments for PING")
-		return []byte("-ERR wrong number of arguments for 'ping' command\r\n")
-	}
+func evalPING(Args []string, c *Client) []byte {
+	// fmt.Printf("Evaluating PING command with %d args: %v\n", len(Args), Args)
 
 	if len(Args) == 0 {
 		// fmt.Println("PING with no args, returning PONG")
-		return Encode("PONG", true)
+		return c.addReply(SimpleString("PONG"))
 	} else {
 		// fmt.Printf("PING with arg: %s\n", Args[0])
-		return Encode(Args[0], false)
+		return c.addReply(Args[0])
 	}
 }
 
-func EvalAndResponse(Command *RedisCmd) []byte {
+// EvalAndResponse runs a command through the command table and queues the
+// encoded reply in c.Out. Unknown commands and arity errors are answered
+// here, so handlers only validate the meaning of their arguments. Nothing is
+// queued when the command blocked the client.
+func EvalAndResponse(Command *RedisCmd, c *Client) {
+	c.LastInteraction = time.Now()
+	c.LastCmd = Command.Cmd
 	// fmt.Printf("Evaluating command: %s with args: %v\n", Command.Cmd, Command.Args)
 
-	switch Command.Cmd {
-	case "PING":
-		return evalPING(Command.Args)
-	case "ECHO":
-		return evalECHO(Command.Args)
-	case "TIME":
-		return evalTIME(Command.Args)
-	case "SET":
-		return evalSET(Command.Args)
-	case "GET":
-		return evalGET(Command.Args)
-	case "TTL":
-		return evalTTL(Command.Args)
-	case "DEL":
-		return evalDEL(Command.Args)
-	case "EXPIRE":
-		return evalEXPIRE(Command.Args)
-	default:
+	cmd := LookupCommand(Command.Cmd)
+	if cmd == nil {
 		// fmt.Printf("Command %s not supported\n", Command.Cmd)
-		return []byte(fmt.Sprintf("-ERR unknown command '%s'\r\n", Command.Cmd))
-	}
+		c.Out = append(c.Out, unknownCommandError(Command.Cmd, Command.Args)...)
+		return
+	}
+	selectDb(c.DB)
+	start := len(c.Out)
+	reply := cmd.call(Command.Args, c)
+	// A reply built with addReply is the tail of c.Out already. Anything
+	// else is copied in, replacing whatever was queued on the way to it.
+	if len(reply) != len(c.Out)-start || (len(reply) > 0 && &reply[0] != &c.Out[start]) {
+		c.Out = append(c.Out[:start], reply...)
+	}
+	handleClientsBlockedOnKeys()
 }
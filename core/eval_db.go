@@ -0,0 +1,119 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lookupDb parses a database index
+func lookupDb(arg string) (*redisDb, []byte) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if id < 0 || id >= int64(len(dbs)) {
+		return nil, []byte("-ERR DB index is out of range\r\n")
+	}
+	return dbs[id], nil
+}
+
+// SELECT index
+func evalSELECT(Args []string, c *Client) []byte {
+	d, errReply := lookupDb(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	c.DB = d.id
+	selectDb(d.id)
+	return RESP_OK
+}
+
+// MOVE key db
+// moves key, with its expiry, unless the target database has it already
+func evalMOVE(Args []string, c *Client) []byte {
+	key := Args[0]
+	target, errReply := lookupDb(Args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if target == db {
+		return []byte("-ERR source and destination objects are the same\r\n")
+	}
+	obj := Peek(key)
+	if obj == nil {
+		return c.addReply(0)
+	}
+	source := db
+	db = target
+	defer func() { db = source }()
+	if Peek(key) != nil {
+		return c.addReply(0)
+	}
+	// A move does not add a key overall, so it goes around Put
+	source.remove(key)
+	target.set(key, obj)
+	signalKeyAsReady(key)
+	return c.addReply(1)
+}
+
+// SWAPDB index1 index2
+// swaps the data; clients stay on their database index and see the data
+// of the other one from then on
+func evalSWAPDB(Args []string, c *Client) []byte {
+	a, errReply := lookupDb(Args[0])
+	if errReply != nil {
+		return []byte("-ERR invalid first DB index\r\n")
+	}
+	b, errReply := lookupDb(Args[1])
+	if errReply != nil {
+		return []byte("-ERR invalid second DB index\r\n")
+	}
+	a.dict, b.dict = b.dict, a.dict
+	a.keys, b.keys = b.keys, a.keys
+	a.expires, b.expires = b.expires, a.expires
+	// Keys clients block on may exist now
+	for _, d := range []*redisDb{a, b} {
+		for key := range d.blockingKeys {
+			if _, ok := d.dict[key]; ok {
+				signalKeyAsReadyIn(d, key)
+			}
+		}
+	}
+	return RESP_OK
+}
+
+// parseFlushMode accepts the optional ASYNC or SYNC argument of FLUSHDB
+// and FLUSHALL. Both behave the same: the old keys are dropped at once and
+// left to the garbage collector, so nothing waits on freeing them.
+func parseFlushMode(Args []string) []byte {
+	if len(Args) > 1 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	if len(Args) == 1 {
+		mode := strings.ToUpper(Args[0])
+		if mode != "ASYNC" && mode != "SYNC" {
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	return nil
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func evalFLUSHDB(Args []string, c *Client) []byte {
+	if errReply := parseFlushMode(Args); errReply != nil {
+		return errReply
+	}
+	db.empty()
+	return RESP_OK
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func evalFLUSHALL(Args []string, c *Client) []byte {
+	if errReply := parseFlushMode(Args); errReply != nil {
+		return errReply
+	}
+	for _, d := range dbs {
+		d.empty()
+	}
+	return RESP_OK
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conditions of EXPIRE key ttl [NX|XX|GT|LT]
+const (
+	expireNX = 1 << iota // only when the key has no expiry
+	expireXX             // only when the key has an expiry
+	expireGT             // only when the new expiry is later
+	expireLT             // only when the new expiry is sooner
+)
+
+func parseExpireFlags(opts []string) (int, []byte) {
+	flags := 0
+	for _, opt := range opts {
+		switch strings.ToUpper(opt) {
+		case "NX":
+			flags |= expireNX
+		case "XX":
+			flags |= expireXX
+		case "GT":
+			flags |= expireGT
+		case "LT":
+			flags |= expireLT
+		default:
+			return 0, []byte(fmt.Sprintf("-ERR Unsupported option %s\r\n", opt))
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, []byte("-ERR NX and XX, GT or LT options at the same time are not compatible\r\n")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, []byte("-ERR GT and LT options at the same time are not compatible\r\n")
+	}
+	return flags, nil
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. The
+// argument is relative to basetime (0 for the *AT variants) and in seconds
+// unless inMs is set. A deadline that is already due deletes the key.
+func expireGeneric(Args []string, basetime int64, inMs bool, cmdName string, c *Client) []byte {
+	var key string = Args[0]
+	when, err := strconv.ParseInt(Args[1], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	flags, errReply := parseExpireFlags(Args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	invalid := []byte(fmt.Sprintf("-ERR invalid expire time in '%s' command\r\n", cmdName))
+	if !inMs {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			return invalid
+		}
+		when *= 1000 //store in mili second
+	}
+	if (basetime > 0 && when > math.MaxInt64-basetime) || (basetime < 0 && when < math.MinInt64-basetime) {
+		return invalid
+	}
+	when += basetime
+
+	//get the key, setting an expiry is not an access
+	obj := Peek(key)
+	if obj == nil {
+		//return 0 if key is invalid
+		return c.addReply(0)
+	}
+
+	// A key without expiry counts as an infinite TTL for GT and LT
+	hasTTL := obj.ExpiresAt != -1
+	if (flags&expireNX != 0 && hasTTL) ||
+		(flags&expireXX != 0 && !hasTTL) ||
+		(flags&expireGT != 0 && (!hasTTL || when <= obj.ExpiresAt)) ||
+		(flags&expireLT != 0 && hasTTL && when >= obj.ExpiresAt) {
+		return c.addReply(0)
+	}
+
+	if when <= time.Now().UnixMilli() {
+		Del(key)
+		return c.addReply(1)
+	}
+	db.setExpire(key, obj, when)
+
+	//return 1 success
+	return c.addReply(1)
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+func evalEXPIRE(Args []string, c *Client) []byte {
+	return expireGeneric(Args, time.Now().UnixMilli(), false, "expire", c)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func evalPEXPIRE(Args []string, c *Client) []byte {
+	return expireGeneric(Args, time.Now().UnixMilli(), true, "pexpire", c)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func evalEXPIREAT(Args []string, c *Client) []byte {
+	return expireGeneric(Args, 0, false, "expireat", c)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func evalPEXPIREAT(Args []string, c *Client) []byte {
+	return expireGeneric(Args, 0, true, "pexpireat", c)
+}
+
+// ttlGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME: -2 for a
+// missing key, -1 for a key without expiry, else the remaining time or the
+// absolute deadline
+func ttlGeneric(Args []string, inMs bool, absolute bool, c *Client) []byte {
+	var key string = Args[0]
+
+	// Asking for the TTL must not make the key look recently used
+	obj := Peek(key)
+
+	if obj == nil {
+		return []byte(":-2\r\n")
+	}
+	if obj.ExpiresAt == -1 {
+		return []byte(":-1\r\n")
+	}
+
+	value := obj.ExpiresAt
+	if !absolute {
+		value -= time.Now().UnixMilli()
+		if value < 0 {
+			value = 0
+		}
+	}
+	if !inMs {
+		if absolute {
+			value /= 1000
+		} else {
+			value = (value + 500) / 1000
+		}
+	}
+	return c.addReply(value)
+}
+
+// TTL key
+func evalTTL(Args []string, c *Client) []byte {
+	return ttlGeneric(Args, false, false, c)
+}
+
+// PTTL key
+func evalPTTL(Args []string, c *Client) []byte {
+	return ttlGeneric(Args, true, false, c)
+}
+
+// EXPIRETIME key
+func evalEXPIRETIME(Args []string, c *Client) []byte {
+	return ttlGeneric(Args, false, true, c)
+}
+
+// PEXPIRETIME key
+func evalPEXPIRETIME(Args []string, c *Client) []byte {
+	return ttlGeneric(Args, true, true, c)
+}
+
+// PERSIST key, removes the expiry
+func evalPERSIST(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil || obj.ExpiresAt == -1 {
+		return c.addReply(0)
+	}
+	db.setExpire(Args[0], obj, -1)
+	return c.addReply(1)
+}
@@ -0,0 +1,406 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// getHash returns the hash stored at key, nil if there is none, or the
+// WRONGTYPE reply
+func getHash(key string) (*Hash, *Obj, []byte) {
+	obj, errReply := getTyped(key, ObjHash)
+	if obj == nil {
+		return nil, nil, errReply
+	}
+	return obj.Value.(*Hash), obj, nil
+}
+
+// getOrCreateHash returns the hash at key, storing a new one if needed
+func getOrCreateHash(key string) (*Hash, *Obj, []byte) {
+	h, obj, errReply := getHash(key)
+	if errReply != nil || h != nil {
+		return h, obj, errReply
+	}
+	h = NewHash()
+	obj = NewTypedObj(ObjHash, EncListpack, h, -1)
+	Put(key, obj)
+	return h, obj, nil
+}
+
+// updateHashEncoding reflects a conversion done by Hash.Set in the object
+func updateHashEncoding(obj *Obj) {
+	if !obj.Value.(*Hash).IsCompact() {
+		obj.Encoding = EncHashtable
+	}
+}
+
+// HSET key field value [field value ...]
+func evalHSET(Args []string, c *Client) []byte {
+	if len(Args)%2 != 1 {
+		return []byte("-ERR wrong number of arguments for 'hset' command\r\n")
+	}
+	h, obj, errReply := getOrCreateHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	added := 0
+	for i := 1; i < len(Args); i += 2 {
+		if h.Set(Args[i], Args[i+1]) {
+			added++
+		}
+	}
+	updateHashEncoding(obj)
+	return c.addReply(added)
+}
+
+// HMSET key field value [field value ...], HSET replying OK
+func evalHMSET(Args []string, c *Client) []byte {
+	if len(Args)%2 != 1 {
+		return []byte("-ERR wrong number of arguments for 'hmset' command\r\n")
+	}
+	if reply := evalHSET(Args, c); reply[0] == '-' {
+		return reply
+	}
+	return RESP_OK
+}
+
+// HSETNX key field value
+func evalHSETNX(Args []string, c *Client) []byte {
+	h, obj, errReply := getOrCreateHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if _, exists := h.Get(Args[1]); exists {
+		return c.addReply(0)
+	}
+	h.Set(Args[1], Args[2])
+	updateHashEncoding(obj)
+	return c.addReply(1)
+}
+
+// HGET key field
+func evalHGET(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return c.addReply(nil)
+	}
+	value, ok := h.Get(Args[1])
+	if !ok {
+		return c.addReply(nil)
+	}
+	return c.addReply(value)
+}
+
+// HMGET key field [field ...]
+func evalHMGET(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]interface{}, len(Args)-1)
+	if h != nil {
+		for i, field := range Args[1:] {
+			if value, ok := h.Get(field); ok {
+				reply[i] = value
+			}
+		}
+	}
+	return c.addReply(reply)
+}
+
+// HDEL key field [field ...]
+func evalHDEL(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return c.addReply(0)
+	}
+	deleted := 0
+	for _, field := range Args[1:] {
+		if h.Delete(field) {
+			deleted++
+		}
+	}
+	if h.Len() == 0 {
+		Del(Args[0])
+	}
+	return c.addReply(deleted)
+}
+
+// HLEN key
+func evalHLEN(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(h.Len())
+}
+
+// HEXISTS key field
+func evalHEXISTS(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return c.addReply(0)
+	}
+	if _, ok := h.Get(Args[1]); ok {
+		return c.addReply(1)
+	}
+	return c.addReply(0)
+}
+
+// HSTRLEN key field
+func evalHSTRLEN(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		return c.addReply(0)
+	}
+	value, _ := h.Get(Args[1])
+	return c.addReply(len(value))
+}
+
+// HGETALL key
+func evalHGETALL(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	reply := RespMap{}
+	if h != nil {
+		h.Each(func(field string, value string) {
+			reply = append(reply, field, value)
+		})
+	}
+	return c.addReply(reply)
+}
+
+// HKEYS key
+func evalHKEYS(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	fields := []string{}
+	if h != nil {
+		h.Each(func(field string, value string) {
+			fields = append(fields, field)
+		})
+	}
+	return c.addReply(fields)
+}
+
+// HVALS key
+func evalHVALS(Args []string, c *Client) []byte {
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	values := []string{}
+	if h != nil {
+		h.Each(func(field string, value string) {
+			values = append(values, value)
+		})
+	}
+	return c.addReply(values)
+}
+
+// HINCRBY key field increment
+func evalHINCRBY(Args []string, c *Client) []byte {
+	incr, ok := parseStrictInt(Args[2])
+	if !ok {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	h, obj, errReply := getOrCreateHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	var value int64 = 0
+	if current, ok := h.Get(Args[1]); ok {
+		n, ok := parseStrictInt(current)
+		if !ok {
+			return []byte("-ERR hash value is not an integer\r\n")
+		}
+		value = n
+	}
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return []byte("-ERR increment or decrement would overflow\r\n")
+	}
+	value += incr
+	h.Set(Args[1], strconv.FormatInt(value, 10))
+	updateHashEncoding(obj)
+	return c.addReply(value)
+}
+
+// HINCRBYFLOAT key field increment
+func evalHINCRBYFLOAT(Args []string, c *Client) []byte {
+	incr, ok := parseFloatArg(Args[2])
+	if !ok {
+		return []byte("-ERR value is not a valid float\r\n")
+	}
+	if math.IsInf(incr, 0) {
+		return []byte("-ERR value is NaN or Infinity\r\n")
+	}
+	h, obj, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	var value float64 = 0
+	if h != nil {
+		if current, ok := h.Get(Args[1]); ok {
+			f, ok := parseFloatArg(current)
+			if !ok {
+				return []byte("-ERR hash value is not a float\r\n")
+			}
+			value = f
+		}
+	}
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return []byte("-ERR increment would produce NaN or Infinity\r\n")
+	}
+	// The hash is only created once there is a valid value to store
+	if h == nil {
+		h, obj, _ = getOrCreateHash(Args[0])
+	}
+	result := strconv.FormatFloat(value, 'f', -1, 64)
+	h.Set(Args[1], result)
+	updateHashEncoding(obj)
+	return c.addReply(result)
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+func evalHRANDFIELD(Args []string, c *Client) []byte {
+	if len(Args) > 3 || (len(Args) == 3 && strings.ToUpper(Args[2]) != "WITHVALUES") {
+		return []byte("-ERR syntax error\r\n")
+	}
+	hasCount := len(Args) >= 2
+	withValues := len(Args) == 3
+	var count int64 = 1
+	if hasCount {
+		n, err := strconv.ParseInt(Args[1], 10, 64)
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		if n < -math.MaxInt64/2 || n > math.MaxInt64/2 {
+			return []byte("-ERR value is out of range\r\n")
+		}
+		count = n
+	}
+
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if h == nil {
+		if hasCount {
+			return c.addReply([]string{})
+		}
+		return c.addReply(nil)
+	}
+
+	if !hasCount {
+		field, _ := h.Random()
+		return c.addReply(field)
+	}
+
+	// A negative count may return the same field several times. It can ask
+	// for far more fields than the hash holds, so the reply is streamed.
+	if count < 0 {
+		n := -count
+		start := len(c.Out)
+		if withValues && c.Proto < 3 {
+			c.Out = appendHeader(c.Out, '*', 2*n)
+		} else {
+			c.Out = appendHeader(c.Out, '*', n)
+		}
+		for i := int64(0); i < n && !c.outputLimitReached(); i++ {
+			field, value := h.Random()
+			switch {
+			case !withValues:
+				c.Out = appendBulk(c.Out, field)
+			case c.Proto >= 3:
+				c.Out = appendHeader(c.Out, '*', 2)
+				c.Out = appendBulk(appendBulk(c.Out, field), value)
+			default:
+				c.Out = appendBulk(appendBulk(c.Out, field), value)
+			}
+		}
+		return c.Out[start:]
+	}
+
+	// A positive count returns distinct fields
+	fields := sampleDistinct(h.Len(), int(min(count, int64(h.Len()))),
+		func() string {
+			field, _ := h.Random()
+			return field
+		},
+		func(fn func(string) bool) {
+			h.Each(func(field string, value string) { fn(field) })
+		})
+	reply := []interface{}{}
+	for _, field := range fields {
+		value, _ := h.Get(field)
+		switch {
+		case !withValues:
+			reply = append(reply, field)
+		case c.Proto >= 3:
+			reply = append(reply, []interface{}{field, value})
+		default:
+			reply = append(reply, field, value)
+		}
+	}
+	return c.addReply(reply)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func evalHSCAN(Args []string, c *Client) []byte {
+	opts, errReply := parseScanArgs(Args[1:], false, true)
+	if errReply != nil {
+		return errReply
+	}
+	h, _, errReply := getHash(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	elements := []string{}
+	if h == nil {
+		return scanReply(0, elements, c)
+	}
+
+	// A compact hash is returned whole in one call, as Redis does
+	var fields []string
+	next := uint64(0)
+	if h.IsCompact() {
+		h.Each(func(field string, value string) {
+			fields = append(fields, field)
+		})
+	} else {
+		fields, next = h.index.scan(opts.cursor, opts.count)
+	}
+	for _, field := range fields {
+		if !opts.matches(field) {
+			continue
+		}
+		elements = append(elements, field)
+		if !opts.noValues {
+			value, _ := h.Get(field)
+			elements = append(elements, value)
+		}
+	}
+	return scanReply(next, elements, c)
+}
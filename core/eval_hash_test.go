@@ -0,0 +1,44 @@
+package core
+
+import "testing"
+
+func TestHashIncrements(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    string
+		want   string
+		exists bool // whether h exists afterwards
+	}{
+		{"HINCRBY creates the hash", "HINCRBY h f 5", ":5\r\n", true},
+		{"HINCRBY rejects a plus sign like INCRBY", "HINCRBY h f +5", "-ERR value is not an integer or out of range\r\n", false},
+		{"HINCRBY rejects leading zeros", "HINCRBY h f 05", "-ERR value is not an integer or out of range\r\n", false},
+		{"HINCRBYFLOAT creates the hash", "HINCRBYFLOAT h f 1.5", "$3\r\n1.5\r\n", true},
+		{"HINCRBYFLOAT rejects inf", "HINCRBYFLOAT h f inf", "-ERR value is NaN or Infinity\r\n", false},
+		{"HINCRBYFLOAT rejects -inf", "HINCRBYFLOAT h f -inf", "-ERR value is NaN or Infinity\r\n", false},
+		{"HINCRBYFLOAT rejects nan", "HINCRBYFLOAT h f nan", "-ERR value is not a valid float\r\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			c := NewClient(-1, "", "")
+			if got := run(c, tt.cmd); got != tt.want {
+				t.Fatalf("%s replied %q, want %q", tt.cmd, got, tt.want)
+			}
+			if exists := run(c, "EXISTS h") == ":1\r\n"; exists != tt.exists {
+				t.Errorf("h exists: %v, want %v", exists, tt.exists)
+			}
+		})
+	}
+}
+
+func TestHINCRBYFLOATOverflowKeepsValue(t *testing.T) {
+	resetServer(t)
+	c := NewClient(-1, "", "")
+	run(c, "HSET h f 1e308")
+	if got := run(c, "HINCRBYFLOAT h f 1e308"); got != "-ERR increment would produce NaN or Infinity\r\n" {
+		t.Fatalf("overflowing HINCRBYFLOAT replied %q", got)
+	}
+	if got := run(c, "HGET h f"); got != "$5\r\n1e308\r\n" {
+		t.Errorf("HGET after the failed increment replied %q", got)
+	}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var serverStartTime = time.Now()
+
+// infoSections are the sections INFO knows, in the order it prints them
+var infoSections = []struct {
+	name  string
+	build func() []string
+}{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"keyspace", infoKeyspace},
+}
+
+func infoServer() []string {
+	uptime := int64(time.Since(serverStartTime).Seconds())
+	return []string{
+		"redis_version:7.2.0",
+		"redis_mode:standalone",
+		"arch_bits:64",
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("uptime_in_seconds:%d", uptime),
+		fmt.Sprintf("uptime_in_days:%d", uptime/(24*3600)),
+	}
+}
+
+func infoClients() []string {
+	blocked := 0
+	for _, c := range clients {
+		if c.blocked != nil {
+			blocked++
+		}
+	}
+	return []string{
+		fmt.Sprintf("connected_clients:%d", len(clients)),
+		fmt.Sprintf("blocked_clients:%d", blocked),
+	}
+}
+
+// infoKeyspace has a line per database holding keys: how many, how many
+// with an expiry and their average time to live in milliseconds
+func infoKeyspace() []string {
+	lines := []string{}
+	now := time.Now().UnixMilli()
+	for _, d := range dbs {
+		if len(d.dict) == 0 {
+			continue
+		}
+		expires := len(d.expires)
+		var ttlSum int64
+		for _, obj := range d.expires {
+			ttlSum += max(obj.ExpiresAt-now, 0)
+		}
+		avgTTL := int64(0)
+		if expires > 0 {
+			avgTTL = ttlSum / int64(expires)
+		}
+		lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=%d", d.id, len(d.dict), expires, avgTTL))
+	}
+	return lines
+}
+
+// INFO [section [section ...]]
+// without a section, or with default, all or everything, every section is
+// returned; unknown sections are left out
+func evalINFO(Args []string, c *Client) []byte {
+	wanted := make(map[string]bool)
+	all := len(Args) == 0
+	for _, arg := range Args {
+		section := strings.ToLower(arg)
+		if section == "default" || section == "all" || section == "everything" {
+			all = true
+		}
+		wanted[section] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, line := range section.build() {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	return c.addReply(VerbatimString{Format: "txt", Text: b.String()})
+}
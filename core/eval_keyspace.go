@@ -0,0 +1,162 @@
+package core
+
+import "strings"
+
+// EXISTS key [key ...]
+// counts a key once for every time it is named
+func evalEXISTS(Args []string, c *Client) []byte {
+	count := 0
+	for _, key := range Args {
+		if Peek(key) != nil {
+			count++
+		}
+	}
+	return c.addReply(count)
+}
+
+// KEYS pattern
+func evalKEYS(Args []string, c *Client) []byte {
+	keys := []string{}
+	for key := range db.dict {
+		if globMatch(Args[0], key) && Peek(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	return c.addReply(keys)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func evalSCAN(Args []string, c *Client) []byte {
+	opts, errReply := parseScanArgs(Args, true, false)
+	if errReply != nil {
+		return errReply
+	}
+	keys, next := db.keys.scan(opts.cursor, opts.count)
+	elements := []string{}
+	for _, key := range keys {
+		if !opts.matches(key) {
+			continue
+		}
+		obj := Peek(key)
+		if obj == nil || (opts.objType != "" && obj.TypeName() != opts.objType) {
+			continue
+		}
+		elements = append(elements, key)
+	}
+	return scanReply(next, elements, c)
+}
+
+// RANDOMKEY
+func evalRANDOMKEY(Args []string, c *Client) []byte {
+	key, ok := randomKey()
+	if !ok {
+		return c.addReply(nil)
+	}
+	return c.addReply(key)
+}
+
+// DBSIZE
+// like Redis it counts keys that expired but were not deleted yet
+func evalDBSIZE(Args []string, c *Client) []byte {
+	return c.addReply(len(db.dict))
+}
+
+// renameGeneric moves the value at src to dst, expiry included. With nx
+// set it gives up when dst exists and replies 0 or 1, otherwise OK.
+func renameGeneric(src string, dst string, nx bool, c *Client) []byte {
+	obj := Peek(src)
+	if obj == nil {
+		return []byte("-ERR no such key\r\n")
+	}
+	if src == dst {
+		if nx {
+			return c.addReply(0)
+		}
+		return RESP_OK
+	}
+	if nx && Peek(dst) != nil {
+		return c.addReply(0)
+	}
+	// The key moves with its own expiry and access history
+	Del(src)
+	db.set(dst, obj)
+	if nx {
+		return c.addReply(1)
+	}
+	return RESP_OK
+}
+
+// RENAME key newkey
+func evalRENAME(Args []string, c *Client) []byte {
+	return renameGeneric(Args[0], Args[1], false, c)
+}
+
+// RENAMENX key newkey
+func evalRENAMENX(Args []string, c *Client) []byte {
+	return renameGeneric(Args[0], Args[1], true, c)
+}
+
+// dupObj returns a copy of obj that shares nothing with it, expiry
+// included
+func dupObj(obj *Obj) *Obj {
+	value := obj.Value
+	switch v := obj.Value.(type) {
+	case *List:
+		value = v.Dup()
+	case *Hash:
+		value = v.Dup()
+	case *Set:
+		value = v.Dup()
+	case *ZSet:
+		value = v.Dup()
+	}
+	dup := NewTypedObj(obj.Type, obj.Encoding, value, -1)
+	dup.ExpiresAt = obj.ExpiresAt
+	return dup
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func evalCOPY(Args []string, c *Client) []byte {
+	src, dst := Args[0], Args[1]
+	replace := false
+	target := db
+	for i := 2; i < len(Args); i++ {
+		opt := strings.ToUpper(Args[i])
+		switch {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "DB" && i+1 < len(Args):
+			d, errReply := lookupDb(Args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			target = d
+			i++
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	if src == dst && target == db {
+		return []byte("-ERR source and destination objects are the same\r\n")
+	}
+	obj := Peek(src)
+	if obj == nil {
+		return c.addReply(0)
+	}
+
+	// The destination is written in the target database
+	source := db
+	db = target
+	defer func() { db = source }()
+	if Peek(dst) != nil {
+		if !replace {
+			return c.addReply(0)
+		}
+		Del(dst)
+	}
+	Put(dst, dupObj(obj))
+	if target != source {
+		signalKeyAsReady(dst)
+	}
+	return c.addReply(1)
+}
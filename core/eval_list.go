@@ -0,0 +1,553 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// listMaxListpackSize is list-max-listpack-size -2: a list is reported as a
+// listpack while its elements fit in 8kb, and as a quicklist after that
+const listMaxListpackSize = 8 * 1024
+
+const (
+	listHead = iota
+	listTail
+)
+
+// updateListEncoding switches the encoding once the list outgrows a single
+// listpack, and back when it has shrunk to half of that, like Redis 7.2
+func updateListEncoding(obj *Obj) {
+	l := obj.Value.(*List)
+	if obj.Encoding == EncListpack && l.bytes > listMaxListpackSize {
+		obj.Encoding = EncQuicklist
+	} else if obj.Encoding == EncQuicklist && l.bytes <= listMaxListpackSize/2 {
+		obj.Encoding = EncListpack
+	}
+}
+
+// getList returns the list stored at key, nil if there is none, or the
+// WRONGTYPE reply
+func getList(key string) (*List, *Obj, []byte) {
+	obj, errReply := getTyped(key, ObjList)
+	if obj == nil {
+		return nil, nil, errReply
+	}
+	return obj.Value.(*List), obj, nil
+}
+
+// createList stores a new empty list at key
+func createList(key string) (*List, *Obj) {
+	l := NewList()
+	obj := NewTypedObj(ObjList, EncListpack, l, -1)
+	Put(key, obj)
+	return l, obj
+}
+
+// deleteIfEmpty removes a list key once its last element is gone
+func deleteIfEmpty(key string, l *List) {
+	if l.Len() == 0 {
+		Del(key)
+	}
+}
+
+// parseWhere parses LEFT|RIGHT
+func parseWhere(arg string) (int, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return listHead, true
+	case "RIGHT":
+		return listTail, true
+	}
+	return 0, false
+}
+
+// listRange clamps start and end like LRANGE and LTRIM do: negative
+// indexes count from the tail and the range is cut to the list. It reports
+// false when nothing is left.
+func listRange(start int64, end int64, size int) (int, int, bool) {
+	n := int64(size)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= n {
+		return 0, 0, false
+	}
+	if end >= n {
+		end = n - 1
+	}
+	return int(start), int(end), true
+}
+
+// listIndex turns a possibly negative index into a position in the list
+func listIndex(index int64, size int) (int, bool) {
+	if index < 0 {
+		index += int64(size)
+	}
+	if index < 0 || index >= int64(size) {
+		return 0, false
+	}
+	return int(index), true
+}
+
+func listPop(l *List, where int) string {
+	if where == listHead {
+		return l.PopFront()
+	}
+	return l.PopBack()
+}
+
+func listPush(l *List, where int, value string) {
+	if where == listHead {
+		l.PushFront(value)
+	} else {
+		l.PushBack(value)
+	}
+}
+
+// pushGeneric implements LPUSH, RPUSH, LPUSHX and RPUSHX
+func pushGeneric(Args []string, where int, onlyIfExists bool, c *Client) []byte {
+	key := Args[0]
+	l, obj, errReply := getList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		if onlyIfExists {
+			return c.addReply(0)
+		}
+		l, obj = createList(key)
+	}
+	for _, value := range Args[1:] {
+		listPush(l, where, value)
+	}
+	updateListEncoding(obj)
+	return c.addReply(l.Len())
+}
+
+// LPUSH key element [element ...]
+func evalLPUSH(Args []string, c *Client) []byte {
+	return pushGeneric(Args, listHead, false, c)
+}
+
+// RPUSH key element [element ...]
+func evalRPUSH(Args []string, c *Client) []byte {
+	return pushGeneric(Args, listTail, false, c)
+}
+
+// LPUSHX key element [element ...]
+func evalLPUSHX(Args []string, c *Client) []byte {
+	return pushGeneric(Args, listHead, true, c)
+}
+
+// RPUSHX key element [element ...]
+func evalRPUSHX(Args []string, c *Client) []byte {
+	return pushGeneric(Args, listTail, true, c)
+}
+
+// popGeneric implements LPOP and RPOP. Without a count the reply is the
+// element (or nil), with one it is an array (or a nil array).
+func popGeneric(Args []string, where int, c *Client) []byte {
+	if len(Args) > 2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	hasCount := len(Args) == 2
+	count := int64(1)
+	if hasCount {
+		n, err := strconv.ParseInt(Args[1], 10, 64)
+		if err != nil || n < 0 {
+			return []byte("-ERR value is out of range, must be positive\r\n")
+		}
+		count = n
+	}
+
+	key := Args[0]
+	l, obj, errReply := getList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		if hasCount {
+			return c.addReply(NullArray{})
+		}
+		return c.addReply(nil)
+	}
+	if !hasCount {
+		value := listPop(l, where)
+		updateListEncoding(obj)
+		deleteIfEmpty(key, l)
+		return c.addReply(value)
+	}
+
+	values := make([]string, 0, min(count, int64(l.Len())))
+	for int64(len(values)) < count && l.Len() > 0 {
+		values = append(values, listPop(l, where))
+	}
+	updateListEncoding(obj)
+	deleteIfEmpty(key, l)
+	return c.addReply(values)
+}
+
+// LPOP key [count]
+func evalLPOP(Args []string, c *Client) []byte {
+	return popGeneric(Args, listHead, c)
+}
+
+// RPOP key [count]
+func evalRPOP(Args []string, c *Client) []byte {
+	return popGeneric(Args, listTail, c)
+}
+
+// LLEN key
+func evalLLEN(Args []string, c *Client) []byte {
+	l, _, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(l.Len())
+}
+
+// LRANGE key start stop
+func evalLRANGE(Args []string, c *Client) []byte {
+	start, err1 := strconv.ParseInt(Args[1], 10, 64)
+	end, err2 := strconv.ParseInt(Args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	l, _, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return c.addReply([]string{})
+	}
+	from, to, ok := listRange(start, end, l.Len())
+	if !ok {
+		return c.addReply([]string{})
+	}
+	return c.addReply(l.Range(from, to))
+}
+
+// LINDEX key index
+func evalLINDEX(Args []string, c *Client) []byte {
+	index, err := strconv.ParseInt(Args[1], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	l, _, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return c.addReply(nil)
+	}
+	i, ok := listIndex(index, l.Len())
+	if !ok {
+		return c.addReply(nil)
+	}
+	return c.addReply(l.Index(i))
+}
+
+// LSET key index element
+func evalLSET(Args []string, c *Client) []byte {
+	index, err := strconv.ParseInt(Args[1], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	l, obj, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return []byte("-ERR no such key\r\n")
+	}
+	i, ok := listIndex(index, l.Len())
+	if !ok {
+		return []byte("-ERR index out of range\r\n")
+	}
+	l.Set(i, Args[2])
+	updateListEncoding(obj)
+	return RESP_OK
+}
+
+// LREM key count element
+func evalLREM(Args []string, c *Client) []byte {
+	count, err := strconv.ParseInt(Args[1], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	key := Args[0]
+	l, obj, errReply := getList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return c.addReply(0)
+	}
+	removed := l.RemoveMatching(Args[2], count)
+	updateListEncoding(obj)
+	deleteIfEmpty(key, l)
+	return c.addReply(removed)
+}
+
+// LTRIM key start stop
+func evalLTRIM(Args []string, c *Client) []byte {
+	start, err1 := strconv.ParseInt(Args[1], 10, 64)
+	end, err2 := strconv.ParseInt(Args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	key := Args[0]
+	l, obj, errReply := getList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return RESP_OK
+	}
+	from, to, ok := listRange(start, end, l.Len())
+	if !ok {
+		// keep nothing
+		from, to = 1, 0
+	}
+	l.Trim(from, to)
+	updateListEncoding(obj)
+	deleteIfEmpty(key, l)
+	return RESP_OK
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func evalLINSERT(Args []string, c *Client) []byte {
+	after := false
+	switch strings.ToUpper(Args[1]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return []byte("-ERR syntax error\r\n")
+	}
+	l, obj, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if l == nil {
+		return c.addReply(0)
+	}
+	for i := 0; i < l.Len(); i++ {
+		if l.Index(i) != Args[2] {
+			continue
+		}
+		if after {
+			i++
+		}
+		l.Insert(i, Args[3])
+		updateListEncoding(obj)
+		return c.addReply(l.Len())
+	}
+	return c.addReply(-1)
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func evalLPOS(Args []string, c *Client) []byte {
+	rank, count, maxlen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(Args); i += 2 {
+		if i+1 >= len(Args) {
+			return []byte("-ERR syntax error\r\n")
+		}
+		n, err := strconv.ParseInt(Args[i+1], 10, 64)
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		switch strings.ToUpper(Args[i]) {
+		case "RANK":
+			if n == 0 {
+				return []byte("-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n")
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return []byte("-ERR COUNT can't be negative\r\n")
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				return []byte("-ERR MAXLEN can't be negative\r\n")
+			}
+			maxlen = n
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	l, _, errReply := getList(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	matches := []int64{}
+	if l != nil {
+		// Skip rank-1 matches, then collect count of them (0 means all)
+		skip := rank - 1
+		fromTail := rank < 0
+		if fromTail {
+			skip = -rank - 1
+		}
+		want := count
+		if want == -1 {
+			want = 1
+		}
+		size := l.Len()
+		for n := 0; n < size && (maxlen == 0 || int64(n) < maxlen); n++ {
+			i := n
+			if fromTail {
+				i = size - 1 - n
+			}
+			if l.Index(i) != Args[1] {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			matches = append(matches, int64(i))
+			if want > 0 && int64(len(matches)) == want {
+				break
+			}
+		}
+	}
+
+	if count == -1 {
+		if len(matches) == 0 {
+			return c.addReply(nil)
+		}
+		return c.addReply(matches[0])
+	}
+	return c.addReply(matches)
+}
+
+// moveGeneric pops from one end of src and pushes to one end of dst
+func moveGeneric(src string, dst string, from int, to int, c *Client) []byte {
+	srcList, srcObj, errReply := getList(src)
+	if errReply != nil {
+		return errReply
+	}
+	if srcList == nil {
+		return c.addReply(nil)
+	}
+	dstList, dstObj, errReply := getList(dst)
+	if errReply != nil {
+		return errReply
+	}
+
+	value := listPop(srcList, from)
+	if dstList == nil {
+		// dst can only be missing when it is a different key from src
+		dstList, dstObj = createList(dst)
+	}
+	listPush(dstList, to, value)
+	updateListEncoding(srcObj)
+	updateListEncoding(dstObj)
+	deleteIfEmpty(src, srcList)
+	return c.addReply(value)
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func evalLMOVE(Args []string, c *Client) []byte {
+	from, ok1 := parseWhere(Args[2])
+	to, ok2 := parseWhere(Args[3])
+	if !ok1 || !ok2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	return moveGeneric(Args[0], Args[1], from, to, c)
+}
+
+// RPOPLPUSH source destination
+func evalRPOPLPUSH(Args []string, c *Client) []byte {
+	return moveGeneric(Args[0], Args[1], listTail, listHead, c)
+}
+
+// parseNumKeys reads the numkeys argument of LMPOP style commands from
+// Args[0] and checks that many keys follow it
+func parseNumKeys(Args []string) (int, []byte) {
+	numkeys, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return 0, []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if numkeys <= 0 {
+		return 0, []byte("-ERR numkeys should be greater than 0\r\n")
+	}
+	if numkeys > int64(len(Args)-1) {
+		return 0, []byte("-ERR syntax error\r\n")
+	}
+	return int(numkeys), nil
+}
+
+// numKeysPositions finds the keys of commands shaped like
+// CMD numkeys key [key ...] ..., numkeys being argv[1]
+func numKeysPositions(argv []string) []int {
+	if len(argv) < 2 {
+		return nil
+	}
+	numkeys, errReply := parseNumKeys(argv[1:])
+	if errReply != nil {
+		return nil
+	}
+	positions := make([]int, numkeys)
+	for i := range positions {
+		positions[i] = 2 + i
+	}
+	return positions
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func evalLMPOP(Args []string, c *Client) []byte {
+	numkeys, errReply := parseNumKeys(Args)
+	if errReply != nil {
+		return errReply
+	}
+	keys := Args[1 : 1+numkeys]
+	rest := Args[1+numkeys:]
+	if len(rest) == 0 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	where, ok := parseWhere(rest[0])
+	if !ok {
+		return []byte("-ERR syntax error\r\n")
+	}
+	count := int64(1)
+	if len(rest) > 1 {
+		if len(rest) != 3 || strings.ToUpper(rest[1]) != "COUNT" {
+			return []byte("-ERR syntax error\r\n")
+		}
+		n, err := strconv.ParseInt(rest[2], 10, 64)
+		if err != nil || n <= 0 {
+			return []byte("-ERR count should be greater than 0\r\n")
+		}
+		count = n
+	}
+
+	for _, key := range keys {
+		l, obj, errReply := getList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if l == nil {
+			continue
+		}
+		values := make([]string, 0, min(count, int64(l.Len())))
+		for int64(len(values)) < count && l.Len() > 0 {
+			values = append(values, listPop(l, where))
+		}
+		updateListEncoding(obj)
+		deleteIfEmpty(key, l)
+		return c.addReply([]interface{}{key, values})
+	}
+	return c.addReply(NullArray{})
+}
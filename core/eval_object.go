@@ -0,0 +1,104 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OBJECT ENCODING key
+func evalObjectENCODING(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	return c.addReply(obj.EncodingName())
+}
+
+// OBJECT IDLETIME key, seconds since the key was last read or written
+func evalObjectIDLETIME(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	if isLFUPolicy() {
+		return []byte("-ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n")
+	}
+	return c.addReply((time.Now().UnixMilli() - obj.LastAccess) / 1000)
+}
+
+// OBJECT FREQ key, the logarithmic access counter
+func evalObjectFREQ(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	if !isLFUPolicy() {
+		return []byte("-ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n")
+	}
+	return c.addReply(int(lfuDecr(obj, time.Now().UnixMilli())))
+}
+
+// OBJECT REFCOUNT key. Values are never shared between keys here.
+func evalObjectREFCOUNT(Args []string, c *Client) []byte {
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	return c.addReply(1)
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// helpReply renders help lines as status replies, like Redis' addReplyHelp
+func helpReply(lines []string, c *Client) []byte {
+	reply := make([]interface{}, len(lines))
+	for i, line := range lines {
+		reply[i] = SimpleString(line)
+	}
+	return c.addReply(reply)
+}
+
+// OBJECT HELP
+func evalObjectHELP(Args []string, c *Client) []byte {
+	return helpReply(objectHelp, c)
+}
+
+// MEMORY USAGE key [SAMPLES count]
+func evalMemoryUSAGE(Args []string, c *Client) []byte {
+	samples := 5
+	for i := 1; i < len(Args); i++ {
+		if strings.ToUpper(Args[i]) == "SAMPLES" && i+1 < len(Args) {
+			n, err := strconv.ParseInt(Args[i+1], 10, 64)
+			if err != nil || n < 0 {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			samples = int(min(n, math.MaxInt32))
+			i++
+		} else {
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	obj := Peek(Args[0])
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	return c.addReply(objMemoryUsage(Args[0], obj, samples))
+}
@@ -0,0 +1,477 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// getSet returns the set stored at key, nil if there is none, or the
+// WRONGTYPE reply
+func getSet(key string) (*Set, *Obj, []byte) {
+	obj, errReply := getTyped(key, ObjSet)
+	if obj == nil {
+		return nil, nil, errReply
+	}
+	return obj.Value.(*Set), obj, nil
+}
+
+// setEncoding is the object encoding matching the set's representation
+func setEncoding(s *Set) uint8 {
+	if s.IsIntset() {
+		return EncIntset
+	}
+	return EncHashtable
+}
+
+// newSetObj wraps s in an object
+func newSetObj(s *Set) *Obj {
+	return NewTypedObj(ObjSet, setEncoding(s), s, -1)
+}
+
+// SADD key member [member ...]
+func evalSADD(Args []string, c *Client) []byte {
+	s, obj, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s = NewSet()
+		obj = newSetObj(s)
+		Put(Args[0], obj)
+	}
+	added := 0
+	for _, member := range Args[1:] {
+		if s.Add(member) {
+			added++
+		}
+	}
+	obj.Encoding = setEncoding(s)
+	return c.addReply(added)
+}
+
+// SREM key member [member ...]
+func evalSREM(Args []string, c *Client) []byte {
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return c.addReply(0)
+	}
+	removed := 0
+	for _, member := range Args[1:] {
+		if s.Remove(member) {
+			removed++
+		}
+	}
+	if s.Len() == 0 {
+		Del(Args[0])
+	}
+	return c.addReply(removed)
+}
+
+// membersReply renders members as a set reply (an array in RESP2)
+func membersReply(members []string, c *Client) []byte {
+	reply := make(RespSet, len(members))
+	for i, member := range members {
+		reply[i] = member
+	}
+	return c.addReply(reply)
+}
+
+// SMEMBERS key
+func evalSMEMBERS(Args []string, c *Client) []byte {
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return membersReply(nil, c)
+	}
+	return membersReply(s.Members(), c)
+}
+
+// SISMEMBER key member
+func evalSISMEMBER(Args []string, c *Client) []byte {
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s != nil && s.Contains(Args[1]) {
+		return c.addReply(1)
+	}
+	return c.addReply(0)
+}
+
+// SMISMEMBER key member [member ...]
+func evalSMISMEMBER(Args []string, c *Client) []byte {
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]int64, len(Args)-1)
+	for i, member := range Args[1:] {
+		if s != nil && s.Contains(member) {
+			reply[i] = 1
+		}
+	}
+	return c.addReply(reply)
+}
+
+// SCARD key
+func evalSCARD(Args []string, c *Client) []byte {
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(s.Len())
+}
+
+// lookupSets returns the sets stored at keys, with nil for missing keys,
+// or the WRONGTYPE reply if any key holds something else
+func lookupSets(keys []string) ([]*Set, []byte) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		s, _, errReply := getSet(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// setInter returns the members found in every set, stopping after limit
+// of them when limit > 0
+func setInter(sets []*Set, limit int) []string {
+	members := []string{}
+	smallest := -1
+	for i, s := range sets {
+		if s == nil {
+			return members
+		}
+		if smallest == -1 || s.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	// Walk the smallest set and probe the others
+	sets[smallest].Each(func(member string) bool {
+		for i, s := range sets {
+			if i != smallest && !s.Contains(member) {
+				return true
+			}
+		}
+		members = append(members, member)
+		return limit == 0 || len(members) < limit
+	})
+	return members
+}
+
+// setUnion returns the members found in any of the sets
+func setUnion(sets []*Set) []string {
+	result := NewSet()
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		s.Each(func(member string) bool {
+			result.Add(member)
+			return true
+		})
+	}
+	return result.Members()
+}
+
+// setDiff returns the members of the first set found in none of the others
+func setDiff(sets []*Set) []string {
+	members := []string{}
+	if sets[0] == nil {
+		return members
+	}
+	sets[0].Each(func(member string) bool {
+		for _, s := range sets[1:] {
+			if s != nil && s.Contains(member) {
+				return true
+			}
+		}
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+// Operations shared by SINTER, SUNION, SDIFF and their STORE variants
+const (
+	setOpInter = iota
+	setOpUnion
+	setOpDiff
+)
+
+func setOperation(keys []string, op int) ([]string, []byte) {
+	sets, errReply := lookupSets(keys)
+	if errReply != nil {
+		return nil, errReply
+	}
+	switch op {
+	case setOpInter:
+		return setInter(sets, 0), nil
+	case setOpUnion:
+		return setUnion(sets), nil
+	}
+	return setDiff(sets), nil
+}
+
+func setOperationReply(Args []string, op int, c *Client) []byte {
+	members, errReply := setOperation(Args, op)
+	if errReply != nil {
+		return errReply
+	}
+	return membersReply(members, c)
+}
+
+// setOperationStore stores the result at Args[0] through Put, replacing
+// whatever was there, and replies with its size. An empty result deletes
+// the destination.
+func setOperationStore(Args []string, op int, c *Client) []byte {
+	members, errReply := setOperation(Args[1:], op)
+	if errReply != nil {
+		return errReply
+	}
+	if len(members) == 0 {
+		Del(Args[0])
+		return c.addReply(0)
+	}
+	s := NewSet()
+	for _, member := range members {
+		s.Add(member)
+	}
+	Put(Args[0], newSetObj(s))
+	return c.addReply(s.Len())
+}
+
+// SINTER key [key ...]
+func evalSINTER(Args []string, c *Client) []byte {
+	return setOperationReply(Args, setOpInter, c)
+}
+
+// SUNION key [key ...]
+func evalSUNION(Args []string, c *Client) []byte {
+	return setOperationReply(Args, setOpUnion, c)
+}
+
+// SDIFF key [key ...]
+func evalSDIFF(Args []string, c *Client) []byte {
+	return setOperationReply(Args, setOpDiff, c)
+}
+
+// SINTERSTORE destination key [key ...]
+func evalSINTERSTORE(Args []string, c *Client) []byte {
+	return setOperationStore(Args, setOpInter, c)
+}
+
+// SUNIONSTORE destination key [key ...]
+func evalSUNIONSTORE(Args []string, c *Client) []byte {
+	return setOperationStore(Args, setOpUnion, c)
+}
+
+// SDIFFSTORE destination key [key ...]
+func evalSDIFFSTORE(Args []string, c *Client) []byte {
+	return setOperationStore(Args, setOpDiff, c)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func evalSINTERCARD(Args []string, c *Client) []byte {
+	numkeys, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if numkeys <= 0 {
+		return []byte("-ERR numkeys should be greater than 0\r\n")
+	}
+	if numkeys > int64(len(Args)-1) {
+		return []byte("-ERR Number of keys can't be greater than number of args\r\n")
+	}
+	keys := Args[1 : 1+numkeys]
+	rest := Args[1+numkeys:]
+	limit := int64(0)
+	for i := 0; i < len(rest); i++ {
+		if strings.ToUpper(rest[i]) == "LIMIT" && i+1 < len(rest) {
+			n, err := strconv.ParseInt(rest[i+1], 10, 64)
+			if err != nil {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			if n < 0 {
+				return []byte("-ERR LIMIT can't be negative\r\n")
+			}
+			limit = n
+			i++
+		} else {
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	sets, errReply := lookupSets(keys)
+	if errReply != nil {
+		return errReply
+	}
+	return c.addReply(len(setInter(sets, int(min(limit, math.MaxInt32)))))
+}
+
+// SRANDMEMBER key [count]
+func evalSRANDMEMBER(Args []string, c *Client) []byte {
+	if len(Args) > 2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	hasCount := len(Args) == 2
+	var count int64 = 1
+	if hasCount {
+		n, err := strconv.ParseInt(Args[1], 10, 64)
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		if n < -math.MaxInt64/2 {
+			return []byte("-ERR value is out of range\r\n")
+		}
+		count = n
+	}
+
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if hasCount {
+			return c.addReply([]string{})
+		}
+		return c.addReply(nil)
+	}
+	if !hasCount {
+		return c.addReply(s.Random())
+	}
+
+	// A negative count may return the same member several times. It can ask
+	// for far more members than the set holds, so the reply is streamed.
+	if count < 0 {
+		start := len(c.Out)
+		c.Out = appendHeader(c.Out, '*', -count)
+		for i := int64(0); i < -count && !c.outputLimitReached(); i++ {
+			c.Out = appendBulk(c.Out, s.Random())
+		}
+		return c.Out[start:]
+	}
+
+	// A positive count returns distinct members
+	return c.addReply(sampleDistinct(s.Len(), int(min(count, int64(s.Len()))), s.Random, s.Each))
+}
+
+// SPOP key [count]
+func evalSPOP(Args []string, c *Client) []byte {
+	if len(Args) > 2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	hasCount := len(Args) == 2
+	var count int64 = 1
+	if hasCount {
+		n, err := strconv.ParseInt(Args[1], 10, 64)
+		if err != nil || n < 0 {
+			return []byte("-ERR value is out of range, must be positive\r\n")
+		}
+		count = n
+	}
+
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		if hasCount {
+			return membersReply(nil, c)
+		}
+		return c.addReply(nil)
+	}
+
+	// Every pop is a random pick, so popping stays O(count) however large
+	// the set is
+	var popped []string
+	if count >= int64(s.Len()) {
+		popped = s.Members()
+		Del(Args[0])
+	} else {
+		popped = make([]string, 0, count)
+		for int64(len(popped)) < count {
+			member := s.Random()
+			s.Remove(member)
+			popped = append(popped, member)
+		}
+	}
+	if !hasCount {
+		return c.addReply(popped[0])
+	}
+	return membersReply(popped, c)
+}
+
+// SMOVE source destination member
+func evalSMOVE(Args []string, c *Client) []byte {
+	src, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	dst, dstObj, errReply := getSet(Args[1])
+	if errReply != nil {
+		return errReply
+	}
+	if src == nil || !src.Contains(Args[2]) {
+		return c.addReply(0)
+	}
+	// Same set: nothing moves, but the member is there
+	if src == dst {
+		return c.addReply(1)
+	}
+	src.Remove(Args[2])
+	if src.Len() == 0 {
+		Del(Args[0])
+	}
+	if dst == nil {
+		dst = NewSet()
+		dstObj = newSetObj(dst)
+		Put(Args[1], dstObj)
+	}
+	dst.Add(Args[2])
+	dstObj.Encoding = setEncoding(dst)
+	return c.addReply(1)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func evalSSCAN(Args []string, c *Client) []byte {
+	opts, errReply := parseScanArgs(Args[1:], false, false)
+	if errReply != nil {
+		return errReply
+	}
+	s, _, errReply := getSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	elements := []string{}
+	if s == nil {
+		return scanReply(0, elements, c)
+	}
+
+	// An intset is returned whole in one call, as Redis does
+	var members []string
+	next := uint64(0)
+	if s.IsIntset() {
+		members = s.Members()
+	} else {
+		members, next = s.index.scan(opts.cursor, opts.count)
+	}
+	for _, member := range members {
+		if opts.matches(member) {
+			elements = append(elements, member)
+		}
+	}
+	return scanReply(next, elements, c)
+}
@@ -0,0 +1,547 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options shared by SET and GETEX
+const (
+	setNX = 1 << iota
+	setXX
+	setGET
+	setKEEPTTL
+	setPERSIST
+	setEX
+	setPX
+	setEXAT
+	setPXAT
+)
+
+const setExpireFlags = setEX | setPX | setEXAT | setPXAT
+
+// parseExpireTime turns the argument of EX/PX/EXAT/PXAT into an absolute
+// unix time in milliseconds. Non-positive values and values that overflow
+// are rejected with Redis' "invalid expire time" error.
+func parseExpireTime(arg string, flag int, cmdName string) (int64, []byte) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	invalid := []byte(fmt.Sprintf("-ERR invalid expire time in '%s' command\r\n", cmdName))
+	if n <= 0 {
+		return 0, invalid
+	}
+	if flag == setEX || flag == setEXAT {
+		if n > math.MaxInt64/1000 {
+			return 0, invalid
+		}
+		n *= 1000
+	}
+	if flag == setEX || flag == setPX {
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			return 0, invalid
+		}
+		n += now
+	}
+	return n, nil
+}
+
+// parseStringOptions parses the options of SET (from Args[2]) and GETEX
+// (from Args[1]). It returns the option flags and the absolute expiry in
+// milliseconds, -1 if none was given, or an error reply.
+func parseStringOptions(Args []string, start int, isSET bool) (int, int64, []byte) {
+	flags := 0
+	expiresAt := int64(-1)
+	syntaxErr := []byte("-ERR syntax error\r\n")
+	cmdName := "set"
+	if !isSET {
+		cmdName = "getex"
+	}
+
+	for i := start; i < len(Args); i++ {
+		opt := strings.ToUpper(Args[i])
+		switch {
+		case opt == "NX" && isSET && flags&(setNX|setXX) == 0:
+			flags |= setNX
+		case opt == "XX" && isSET && flags&(setNX|setXX) == 0:
+			flags |= setXX
+		case opt == "GET" && isSET && flags&setGET == 0:
+			flags |= setGET
+		case opt == "KEEPTTL" && isSET && flags&(setKEEPTTL|setExpireFlags) == 0:
+			flags |= setKEEPTTL
+		case opt == "PERSIST" && !isSET && flags&(setPERSIST|setExpireFlags) == 0:
+			flags |= setPERSIST
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") &&
+			flags&(setKEEPTTL|setPERSIST|setExpireFlags) == 0 && i+1 < len(Args):
+			flag := map[string]int{"EX": setEX, "PX": setPX, "EXAT": setEXAT, "PXAT": setPXAT}[opt]
+			at, errReply := parseExpireTime(Args[i+1], flag, cmdName)
+			if errReply != nil {
+				return 0, 0, errReply
+			}
+			flags |= flag
+			expiresAt = at
+			i++
+		default:
+			return 0, 0, syntaxErr
+		}
+	}
+	return flags, expiresAt, nil
+}
+
+// setKeyWithExpiry stores value under key expiring at the absolute time
+// expiresAt (-1 for never). A time already in the past deletes the key, as
+// Redis does for SET ... EXAT with an old timestamp.
+func setKeyWithExpiry(key string, value interface{}, expiresAt int64) {
+	if expiresAt != -1 && expiresAt <= time.Now().UnixMilli() {
+		Del(key)
+		return
+	}
+	obj := NewObj(value, -1)
+	obj.ExpiresAt = expiresAt
+	setKey(key, obj)
+}
+
+func evalGET(Args []string, c *Client) []byte {
+	var key string = Args[0]
+
+	//Get the object
+	obj, errReply := getTyped(key, ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	// key not exist
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	return c.addReply(objString(obj))
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL]
+func evalSET(Args []string, c *Client) []byte {
+	key, value := Args[0], Args[1]
+	flags, expiresAt, errReply := parseStringOptions(Args, 2, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	old := Get(key)
+	var reply []byte
+	if flags&setGET != 0 {
+		if old != nil && old.Type != ObjString {
+			return RESP_WRONGTYPE
+		}
+		if old == nil {
+			reply = c.addReply(nil)
+		} else {
+			reply = c.addReply(objString(old))
+		}
+	}
+
+	// NX/XX not met: nothing is stored, the reply is nil (or the old value with GET)
+	if (flags&setNX != 0 && old != nil) || (flags&setXX != 0 && old == nil) {
+		if reply != nil {
+			return reply
+		}
+		return c.addReply(nil)
+	}
+
+	if flags&setKEEPTTL != 0 && old != nil {
+		expiresAt = old.ExpiresAt
+	}
+	setKeyWithExpiry(key, newStringValue(value), expiresAt)
+	if reply != nil {
+		return reply
+	}
+	return RESP_OK
+}
+
+// SETNX key value
+func evalSETNX(Args []string, c *Client) []byte {
+	if Get(Args[0]) != nil {
+		return c.addReply(0)
+	}
+	Put(Args[0], NewObj(newStringValue(Args[1]), -1))
+	return c.addReply(1)
+}
+
+// setWithTTL implements SETEX and PSETEX
+func setWithTTL(Args []string, flag int, cmdName string) []byte {
+	expiresAt, errReply := parseExpireTime(Args[1], flag, cmdName)
+	if errReply != nil {
+		return errReply
+	}
+	setKeyWithExpiry(Args[0], newStringValue(Args[2]), expiresAt)
+	return RESP_OK
+}
+
+// SETEX key seconds value
+func evalSETEX(Args []string, c *Client) []byte {
+	return setWithTTL(Args, setEX, "setex")
+}
+
+// PSETEX key milliseconds value
+func evalPSETEX(Args []string, c *Client) []byte {
+	return setWithTTL(Args, setPX, "psetex")
+}
+
+// GETSET key value, sets the value (dropping any TTL) and returns the old one
+func evalGETSET(Args []string, c *Client) []byte {
+	old, errReply := getTyped(Args[0], ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	setKey(Args[0], NewObj(newStringValue(Args[1]), -1))
+	if old == nil {
+		return c.addReply(nil)
+	}
+	return c.addReply(objString(old))
+}
+
+// GETDEL key
+func evalGETDEL(Args []string, c *Client) []byte {
+	obj, errReply := getTyped(Args[0], ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	Del(Args[0])
+	return c.addReply(objString(obj))
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+func evalGETEX(Args []string, c *Client) []byte {
+	flags, expiresAt, errReply := parseStringOptions(Args, 1, false)
+	if errReply != nil {
+		return errReply
+	}
+	obj, errReply := getTyped(Args[0], ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj == nil {
+		return c.addReply(nil)
+	}
+	reply := c.addReply(objString(obj))
+	if flags&setPERSIST != 0 {
+		db.setExpire(Args[0], obj, -1)
+	} else if flags&setExpireFlags != 0 {
+		if expiresAt <= time.Now().UnixMilli() {
+			Del(Args[0])
+		} else {
+			db.setExpire(Args[0], obj, expiresAt)
+		}
+	}
+	return reply
+}
+
+// maxStringLen is proto-max-bulk-len, the largest string value we build
+const maxStringLen = 512 * 1024 * 1024
+
+// objString returns the string held by obj, formatting int encoded values
+func objString(obj *Obj) string {
+	switch v := obj.Value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseStrictInt parses s only if it is the canonical form of an int64
+// (no sign, spaces or leading zeros), like Redis' string2ll
+func parseStrictInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// newStringValue picks the representation of a string value: strings that
+// are integers are kept as int64 (the "int" encoding) so counters don't have
+// to parse them again on every increment
+func newStringValue(s string) interface{} {
+	if n, ok := parseStrictInt(s); ok {
+		return n
+	}
+	return s
+}
+
+// APPEND key value, keeps the key's TTL
+func evalAPPEND(Args []string, c *Client) []byte {
+	key := Args[0]
+	obj, errReply := getTyped(key, ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj == nil {
+		Put(key, NewObj(newStringValue(Args[1]), -1))
+		return c.addReply(len(Args[1]))
+	}
+	current := objString(obj)
+	if len(current)+len(Args[1]) > maxStringLen {
+		return []byte("-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+	}
+	// Appending leaves a string Redis would have to reallocate, always raw
+	newObj := NewTypedObj(ObjString, EncRaw, current+Args[1], -1)
+	newObj.ExpiresAt = obj.ExpiresAt
+	setKey(key, newObj)
+	return c.addReply(len(current) + len(Args[1]))
+}
+
+// STRLEN key
+func evalSTRLEN(Args []string, c *Client) []byte {
+	obj, errReply := getTyped(Args[0], ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(len(objString(obj)))
+}
+
+// GETRANGE key start end, both inclusive and negative from the end
+func evalGETRANGE(Args []string, c *Client) []byte {
+	start, err1 := strconv.ParseInt(Args[1], 10, 64)
+	end, err2 := strconv.ParseInt(Args[2], 10, 64)
+	if err1 != nil || err2 != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	obj, errReply := getTyped(Args[0], ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj == nil {
+		return c.addReply("")
+	}
+	value := objString(obj)
+	strlen := int64(len(value))
+
+	// Convert negative indexes
+	if start < 0 && end < 0 && start > end {
+		return c.addReply("")
+	}
+	if start < 0 {
+		start = strlen + start
+	}
+	if end < 0 {
+		end = strlen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strlen {
+		end = strlen - 1
+	}
+	if strlen == 0 || start > end {
+		return c.addReply("")
+	}
+	return c.addReply(value[start : end+1])
+}
+
+// SETRANGE key offset value, zero-pads when offset is past the end
+func evalSETRANGE(Args []string, c *Client) []byte {
+	key := Args[0]
+	offset, err := strconv.ParseInt(Args[1], 10, 64)
+	if err != nil {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if offset < 0 {
+		return []byte("-ERR offset is out of range\r\n")
+	}
+	value := Args[2]
+
+	obj, errReply := getTyped(key, ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	current := ""
+	if obj != nil {
+		current = objString(obj)
+	}
+	// Nothing to write: report the current length without creating the key
+	if len(value) == 0 {
+		return c.addReply(len(current))
+	}
+	if offset+int64(len(value)) > maxStringLen {
+		return []byte("-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+	}
+
+	newLen := max(len(current), int(offset)+len(value))
+	buf := make([]byte, newLen)
+	copy(buf, current)
+	copy(buf[offset:], value)
+
+	newObj := NewTypedObj(ObjString, EncRaw, string(buf), -1)
+	if obj != nil {
+		newObj.ExpiresAt = obj.ExpiresAt
+	}
+	setKey(key, newObj)
+	return c.addReply(newLen)
+}
+
+// MGET key [key ...], missing keys and keys of other types are nil
+func evalMGET(Args []string, c *Client) []byte {
+	reply := make([]interface{}, len(Args))
+	for i, key := range Args {
+		if obj := Get(key); obj != nil && obj.Type == ObjString {
+			reply[i] = objString(obj)
+		}
+	}
+	return c.addReply(reply)
+}
+
+// MSET key value [key value ...]
+func evalMSET(Args []string, c *Client) []byte {
+	if len(Args)%2 != 0 {
+		return []byte("-ERR wrong number of arguments for 'mset' command\r\n")
+	}
+	for i := 0; i < len(Args); i += 2 {
+		setKey(Args[i], NewObj(newStringValue(Args[i+1]), -1))
+	}
+	return RESP_OK
+}
+
+// MSETNX key value [key value ...], sets nothing if any key exists
+func evalMSETNX(Args []string, c *Client) []byte {
+	if len(Args)%2 != 0 {
+		return []byte("-ERR wrong number of arguments for 'msetnx' command\r\n")
+	}
+	for i := 0; i < len(Args); i += 2 {
+		if Get(Args[i]) != nil {
+			return c.addReply(0)
+		}
+	}
+	for i := 0; i < len(Args); i += 2 {
+		Put(Args[i], NewObj(newStringValue(Args[i+1]), -1))
+	}
+	return c.addReply(1)
+}
+
+// incrDecr adds incr to the integer stored at key (0 when missing), keeping
+// the key's TTL, and replies with the new value
+func incrDecr(key string, incr int64, c *Client) []byte {
+	var value int64 = 0
+	obj, errReply := getTyped(key, ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj != nil {
+		switch v := obj.Value.(type) {
+		case int64:
+			value = v
+		default:
+			n, ok := parseStrictInt(objString(obj))
+			if !ok {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			value = n
+		}
+	}
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return []byte("-ERR increment or decrement would overflow\r\n")
+	}
+	value += incr
+
+	newObj := NewObj(value, -1)
+	if obj != nil {
+		newObj.ExpiresAt = obj.ExpiresAt
+	}
+	setKey(key, newObj)
+	return c.addReply(value)
+}
+
+// INCR key
+func evalINCR(Args []string, c *Client) []byte {
+	return incrDecr(Args[0], 1, c)
+}
+
+// DECR key
+func evalDECR(Args []string, c *Client) []byte {
+	return incrDecr(Args[0], -1, c)
+}
+
+// INCRBY key increment
+func evalINCRBY(Args []string, c *Client) []byte {
+	incr, ok := parseStrictInt(Args[1])
+	if !ok {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	return incrDecr(Args[0], incr, c)
+}
+
+// DECRBY key decrement
+func evalDECRBY(Args []string, c *Client) []byte {
+	decr, ok := parseStrictInt(Args[1])
+	if !ok {
+		return []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if decr == math.MinInt64 {
+		return []byte("-ERR decrement would overflow\r\n")
+	}
+	return incrDecr(Args[0], -decr, c)
+}
+
+// parseFloatArg parses a float the way Redis accepts them: no surrounding
+// spaces, and no NaN
+func parseFloatArg(s string) (float64, bool) {
+	if len(s) == 0 || strings.TrimSpace(s) != s {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// INCRBYFLOAT key increment
+func evalINCRBYFLOAT(Args []string, c *Client) []byte {
+	key := Args[0]
+	var value float64 = 0
+	obj, errReply := getTyped(key, ObjString)
+	if errReply != nil {
+		return errReply
+	}
+	if obj != nil {
+		f, ok := parseFloatArg(objString(obj))
+		if !ok {
+			return []byte("-ERR value is not a valid float\r\n")
+		}
+		value = f
+	}
+	incr, ok := parseFloatArg(Args[1])
+	if !ok {
+		return []byte("-ERR value is not a valid float\r\n")
+	}
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return []byte("-ERR increment would produce NaN or Infinity\r\n")
+	}
+
+	// Shortest representation without exponent, e.g. 10.6 rather than 1.06e1
+	result := strconv.FormatFloat(value, 'f', -1, 64)
+	newObj := NewObj(result, -1)
+	if obj != nil {
+		newObj.ExpiresAt = obj.ExpiresAt
+	}
+	setKey(key, newObj)
+	return c.addReply(result)
+}
@@ -0,0 +1,930 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getZSet returns the sorted set stored at key, nil if there is none, or
+// the WRONGTYPE reply
+func getZSet(key string) (*ZSet, *Obj, []byte) {
+	obj, errReply := getTyped(key, ObjZSet)
+	if obj == nil {
+		return nil, nil, errReply
+	}
+	return obj.Value.(*ZSet), obj, nil
+}
+
+// newZSetObj wraps z in an object
+func newZSetObj(z *ZSet) *Obj {
+	return NewTypedObj(ObjZSet, EncSkiplist, z, -1)
+}
+
+// storeZSet replaces whatever is at key with z, or deletes key when z is
+// empty, and replies with the size of z
+func storeZSet(key string, z *ZSet, c *Client) []byte {
+	if z.Len() == 0 {
+		Del(key)
+	} else {
+		Put(key, newZSetObj(z))
+	}
+	return c.addReply(z.Len())
+}
+
+// zsetItemsReply renders items as their members or, with scores, as member
+// and score pairs: one flat array in RESP2, an array of pairs in RESP3
+func zsetItemsReply(items []zsetItem, withScores bool, c *Client) []byte {
+	if !withScores {
+		members := make([]string, len(items))
+		for i, item := range items {
+			members[i] = item.member
+		}
+		return c.addReply(members)
+	}
+	reply := make([]interface{}, 0, 2*len(items))
+	for _, item := range items {
+		if c.Proto >= 3 {
+			reply = append(reply, []interface{}{item.member, item.score})
+		} else {
+			reply = append(reply, item.member, item.score)
+		}
+	}
+	return c.addReply(reply)
+}
+
+// parseScoreBound parses one end of a score range: a float, optionally
+// prefixed with "(" to exclude it
+func parseScoreBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	f, ok := parseFloatArg(s)
+	return f, exclusive, ok
+}
+
+func parseScoreRange(min string, max string) (zscoreRange, []byte) {
+	var r zscoreRange
+	var minOK, maxOK bool
+	r.min, r.minex, minOK = parseScoreBound(min)
+	r.max, r.maxex, maxOK = parseScoreBound(max)
+	if !minOK || !maxOK {
+		return r, []byte("-ERR min or max is not a float\r\n")
+	}
+	return r, nil
+}
+
+// parseLexBound parses one end of a lex range: "-", "+", or a member
+// prefixed with "[" (inclusive) or "(" (exclusive)
+func parseLexBound(s string) (zlexBound, bool) {
+	switch {
+	case s == "-":
+		return zlexBound{inf: -1}, true
+	case s == "+":
+		return zlexBound{inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return zlexBound{value: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return zlexBound{value: s[1:], ex: true}, true
+	}
+	return zlexBound{}, false
+}
+
+func parseLexRange(min string, max string) (zlexRange, []byte) {
+	var r zlexRange
+	var minOK, maxOK bool
+	r.min, minOK = parseLexBound(min)
+	r.max, maxOK = parseLexBound(max)
+	if !minOK || !maxOK {
+		return r, []byte("-ERR min or max not valid string range item\r\n")
+	}
+	return r, nil
+}
+
+// ZADD flags
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddINCR
+)
+
+// zaddGeneric adds the score and member pairs to the sorted set at key
+func zaddGeneric(key string, flags int, pairs []string, c *Client) []byte {
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return []byte("-ERR XX and NX options at the same time are not compatible\r\n")
+	}
+	if flags&zaddNX != 0 && flags&(zaddGT|zaddLT) != 0 || flags&zaddGT != 0 && flags&zaddLT != 0 {
+		return []byte("-ERR GT, LT, and/or NX options at the same time are not compatible\r\n")
+	}
+	if flags&zaddINCR != 0 && len(pairs) > 2 {
+		return []byte("-ERR INCR option supports a single increment-element pair\r\n")
+	}
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		score, ok := parseFloatArg(pairs[2*i])
+		if !ok {
+			return []byte("-ERR value is not a valid float\r\n")
+		}
+		scores[i] = score
+	}
+
+	z, _, errReply := getZSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	created := false
+	if z == nil {
+		if flags&zaddXX != 0 {
+			if flags&zaddINCR != 0 {
+				return c.addReply(nil)
+			}
+			return c.addReply(0)
+		}
+		z = NewZSet()
+		created = true
+	}
+
+	added, updated := 0, 0
+	var score float64
+	processed := false
+	for i, member := range pairsMembers(pairs) {
+		score = scores[i]
+		current, exists := z.Score(member)
+		if exists {
+			if flags&zaddNX != 0 {
+				continue
+			}
+			if flags&zaddINCR != 0 {
+				score += current
+				if math.IsNaN(score) {
+					return []byte("-ERR resulting score is not a number (NaN)\r\n")
+				}
+			}
+			if flags&zaddLT != 0 && score >= current || flags&zaddGT != 0 && score <= current {
+				continue
+			}
+			if score != current {
+				z.Add(member, score)
+				updated++
+			}
+		} else {
+			if flags&zaddXX != 0 {
+				continue
+			}
+			z.Add(member, score)
+			added++
+		}
+		processed = true
+	}
+	if created && z.Len() > 0 {
+		Put(key, newZSetObj(z))
+	}
+
+	if flags&zaddINCR != 0 {
+		if !processed {
+			return c.addReply(nil)
+		}
+		return c.addReply(score)
+	}
+	if flags&zaddCH != 0 {
+		return c.addReply(added + updated)
+	}
+	return c.addReply(added)
+}
+
+// pairsMembers returns the members of a score and member list
+func pairsMembers(pairs []string) []string {
+	members := make([]string, len(pairs)/2)
+	for i := range members {
+		members[i] = pairs[2*i+1]
+	}
+	return members
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func evalZADD(Args []string, c *Client) []byte {
+	flags := 0
+	i := 1
+flags:
+	for ; i < len(Args); i++ {
+		switch strings.ToUpper(Args[i]) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			flags |= zaddCH
+		case "INCR":
+			flags |= zaddINCR
+		default:
+			break flags
+		}
+	}
+	pairs := Args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	return zaddGeneric(Args[0], flags, pairs, c)
+}
+
+// ZINCRBY key increment member
+func evalZINCRBY(Args []string, c *Client) []byte {
+	return zaddGeneric(Args[0], zaddINCR, Args[1:], c)
+}
+
+// ZREM key member [member ...]
+func evalZREM(Args []string, c *Client) []byte {
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(0)
+	}
+	removed := 0
+	for _, member := range Args[1:] {
+		if z.Remove(member) {
+			removed++
+		}
+	}
+	if z.Len() == 0 {
+		Del(Args[0])
+	}
+	return c.addReply(removed)
+}
+
+// ZCARD key
+func evalZCARD(Args []string, c *Client) []byte {
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(z.Len())
+}
+
+// ZSCORE key member
+func evalZSCORE(Args []string, c *Client) []byte {
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(nil)
+	}
+	score, ok := z.Score(Args[1])
+	if !ok {
+		return c.addReply(nil)
+	}
+	return c.addReply(score)
+}
+
+// ZMSCORE key member [member ...]
+func evalZMSCORE(Args []string, c *Client) []byte {
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	reply := make([]interface{}, len(Args)-1)
+	for i, member := range Args[1:] {
+		if z == nil {
+			continue
+		}
+		if score, ok := z.Score(member); ok {
+			reply[i] = score
+		}
+	}
+	return c.addReply(reply)
+}
+
+// ZCOUNT key min max
+func evalZCOUNT(Args []string, c *Client) []byte {
+	r, errReply := parseScoreRange(Args[1], Args[2])
+	if errReply != nil {
+		return errReply
+	}
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(z.Count(r))
+}
+
+// ZLEXCOUNT key min max
+func evalZLEXCOUNT(Args []string, c *Client) []byte {
+	r, errReply := parseLexRange(Args[1], Args[2])
+	if errReply != nil {
+		return errReply
+	}
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(0)
+	}
+	return c.addReply(z.Count(r))
+}
+
+// How a range command reads its min and max
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeRequest is a parsed ZRANGE family command
+type zrangeRequest struct {
+	by         int
+	reverse    bool
+	withScores bool
+	offset     int64
+	limit      int64 // -1 for no LIMIT
+}
+
+// parseZrangeOptions parses what follows "key min max". The BYSCORE,
+// BYLEX and REV keywords are only accepted by ZRANGE and ZRANGESTORE
+// (unified set), WITHSCORES only when the result is replied (withScores).
+func parseZrangeOptions(req *zrangeRequest, opts []string, unified bool, withScores bool) []byte {
+	hasLimit := false
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(opts[i])
+		switch {
+		case opt == "WITHSCORES" && withScores:
+			req.withScores = true
+		case opt == "LIMIT" && i+2 < len(opts):
+			offset, err1 := strconv.ParseInt(opts[i+1], 10, 64)
+			limit, err2 := strconv.ParseInt(opts[i+2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			req.offset, req.limit = offset, limit
+			hasLimit = true
+			i += 2
+		case opt == "BYSCORE" && unified && req.by == zrangeByRank:
+			req.by = zrangeByScore
+		case opt == "BYLEX" && unified && req.by == zrangeByRank:
+			req.by = zrangeByLex
+		case opt == "REV" && unified:
+			req.reverse = true
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+	if hasLimit && req.by == zrangeByRank {
+		return []byte("-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n")
+	}
+	if req.withScores && req.by == zrangeByLex {
+		return []byte("-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n")
+	}
+	return nil
+}
+
+// zrangeItems runs req over the sorted set at key. For a reversed score or
+// lex range the first bound is the max, as in ZREVRANGEBYSCORE key max min.
+func zrangeItems(key string, start string, end string, req zrangeRequest) ([]zsetItem, []byte) {
+	var r zrange
+	var startIndex, endIndex int64
+	var errReply []byte
+	min, max := start, end
+	if req.reverse {
+		min, max = end, start
+	}
+	switch req.by {
+	case zrangeByRank:
+		var err1, err2 error
+		startIndex, err1 = strconv.ParseInt(start, 10, 64)
+		endIndex, err2 = strconv.ParseInt(end, 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, []byte("-ERR value is not an integer or out of range\r\n")
+		}
+	case zrangeByScore:
+		r, errReply = parseScoreRange(min, max)
+	case zrangeByLex:
+		r, errReply = parseLexRange(min, max)
+	}
+	if errReply != nil {
+		return nil, errReply
+	}
+
+	z, _, errReply := getZSet(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if z == nil || req.offset < 0 {
+		return []zsetItem{}, nil
+	}
+	if req.by == zrangeByRank {
+		from, to, ok := listRange(startIndex, endIndex, z.Len())
+		if !ok {
+			return []zsetItem{}, nil
+		}
+		return z.RangeByRank(from, to, req.reverse), nil
+	}
+	return z.RangeBy(r, req.reverse, req.offset, req.limit), nil
+}
+
+// zrangeGeneric replies to the read only commands of the ZRANGE family
+func zrangeGeneric(Args []string, req zrangeRequest, unified bool, c *Client) []byte {
+	req.limit = -1
+	if errReply := parseZrangeOptions(&req, Args[3:], unified, true); errReply != nil {
+		return errReply
+	}
+	items, errReply := zrangeItems(Args[0], Args[1], Args[2], req)
+	if errReply != nil {
+		return errReply
+	}
+	return zsetItemsReply(items, req.withScores, c)
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func evalZRANGE(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{}, true, c)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func evalZREVRANGE(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{reverse: true}, false, c)
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func evalZRANGEBYSCORE(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{by: zrangeByScore}, false, c)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func evalZREVRANGEBYSCORE(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{by: zrangeByScore, reverse: true}, false, c)
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func evalZRANGEBYLEX(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{by: zrangeByLex}, false, c)
+}
+
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func evalZREVRANGEBYLEX(Args []string, c *Client) []byte {
+	return zrangeGeneric(Args, zrangeRequest{by: zrangeByLex, reverse: true}, false, c)
+}
+
+// ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func evalZRANGESTORE(Args []string, c *Client) []byte {
+	req := zrangeRequest{limit: -1}
+	if errReply := parseZrangeOptions(&req, Args[4:], true, false); errReply != nil {
+		return errReply
+	}
+	items, errReply := zrangeItems(Args[1], Args[2], Args[3], req)
+	if errReply != nil {
+		return errReply
+	}
+	z := NewZSet()
+	for _, item := range items {
+		z.Add(item.member, item.score)
+	}
+	return storeZSet(Args[0], z, c)
+}
+
+// zrankGeneric replies to ZRANK and ZREVRANK
+func zrankGeneric(Args []string, reverse bool, c *Client) []byte {
+	withScore := false
+	if len(Args) > 2 {
+		if len(Args) > 3 || strings.ToUpper(Args[2]) != "WITHSCORE" {
+			return []byte("-ERR syntax error\r\n")
+		}
+		withScore = true
+	}
+	var notFound interface{}
+	if withScore {
+		notFound = NullArray{}
+	}
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil {
+		return c.addReply(notFound)
+	}
+	rank, ok := z.Rank(Args[1], reverse)
+	if !ok {
+		return c.addReply(notFound)
+	}
+	if withScore {
+		score, _ := z.Score(Args[1])
+		return c.addReply([]interface{}{rank, score})
+	}
+	return c.addReply(rank)
+}
+
+// ZRANK key member [WITHSCORE]
+func evalZRANK(Args []string, c *Client) []byte {
+	return zrankGeneric(Args, false, c)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func evalZREVRANK(Args []string, c *Client) []byte {
+	return zrankGeneric(Args, true, c)
+}
+
+// zsetPop removes and returns up to count elements with the lowest scores,
+// or the highest ones with max set, deleting key once it is empty
+func zsetPop(key string, z *ZSet, count int, max bool) []zsetItem {
+	items := z.RangeByRank(0, min(count, z.Len())-1, max)
+	for _, item := range items {
+		z.Remove(item.member)
+	}
+	if z.Len() == 0 {
+		Del(key)
+	}
+	return items
+}
+
+// zpopGeneric replies to ZPOPMIN and ZPOPMAX
+func zpopGeneric(Args []string, max bool, c *Client) []byte {
+	if len(Args) > 2 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	hasCount := len(Args) == 2
+	count := int64(1)
+	if hasCount {
+		n, err := strconv.ParseInt(Args[1], 10, 64)
+		if err != nil {
+			return []byte("-ERR value is not an integer or out of range\r\n")
+		}
+		if n < 0 {
+			return []byte("-ERR value is out of range, must be positive\r\n")
+		}
+		count = n
+	}
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	if z == nil || count == 0 {
+		return c.addReply([]string{})
+	}
+	items := zsetPop(Args[0], z, int(min(count, math.MaxInt32)), max)
+	if hasCount {
+		return zsetItemsReply(items, true, c)
+	}
+	// Without a count the pair comes back flat in RESP3 as well
+	return c.addReply([]interface{}{items[0].member, items[0].score})
+}
+
+// ZPOPMIN key [count]
+func evalZPOPMIN(Args []string, c *Client) []byte {
+	return zpopGeneric(Args, false, c)
+}
+
+// ZPOPMAX key [count]
+func evalZPOPMAX(Args []string, c *Client) []byte {
+	return zpopGeneric(Args, true, c)
+}
+
+// bzpopGeneric replies to BZPOPMIN and BZPOPMAX: it pops from the first
+// non empty key, or blocks until a write fills one of them
+func bzpopGeneric(Args []string, max bool, c *Client) []byte {
+	keys := Args[:len(Args)-1]
+	deadline, errReply := parseBlockTimeout(Args[len(Args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	// Also used to serve the client once blocked, when the reply is kept
+	// aside rather than queued, so it is encoded on its own
+	pop := func(key string, z *ZSet, c *Client) []byte {
+		item := zsetPop(key, z, 1, max)[0]
+		return EncodeProto([]interface{}{key, item.member, item.score}, false, c.Proto)
+	}
+	for _, key := range keys {
+		z, _, errReply := getZSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if z != nil {
+			return pop(key, z, c)
+		}
+	}
+
+	serve := func(c *Client, key string) []byte {
+		z, _, errReply := getZSet(key)
+		if errReply != nil || z == nil {
+			return nil
+		}
+		reply := pop(key, z, c)
+		// The pop is a write of its own, WAITKEY clients get to see it
+		signalKeyAsReady(key)
+		return reply
+	}
+	blockForKeys(c, keys, deadline, serve, EncodeProto(NullArray{}, false, c.Proto))
+	return nil
+}
+
+// BZPOPMIN key [key ...] timeout
+func evalBZPOPMIN(Args []string, c *Client) []byte {
+	return bzpopGeneric(Args, false, c)
+}
+
+// BZPOPMAX key [key ...] timeout
+func evalBZPOPMAX(Args []string, c *Client) []byte {
+	return bzpopGeneric(Args, true, c)
+}
+
+// Sorted set operations
+const (
+	zsetUnion = iota
+	zsetInter
+	zsetDiff
+)
+
+var zsetOpNames = map[int]string{
+	zsetUnion: "zunion",
+	zsetInter: "zinter",
+	zsetDiff:  "zdiff",
+}
+
+// zsetOperand returns the elements of the sorted set at key; a plain set
+// counts as a sorted set with every score at 1
+func zsetOperand(key string) (map[string]float64, []byte) {
+	obj := Get(key)
+	if obj == nil {
+		return nil, nil
+	}
+	switch obj.Type {
+	case ObjZSet:
+		return obj.Value.(*ZSet).dict, nil
+	case ObjSet:
+		members := obj.Value.(*Set).Members()
+		scores := make(map[string]float64, len(members))
+		for _, member := range members {
+			scores[member] = 1
+		}
+		return scores, nil
+	}
+	return nil, RESP_WRONGTYPE
+}
+
+// zsetAggregate combines two scores the way AGGREGATE asks, a NaN sum
+// (inf + -inf) counting as 0 like in Redis
+func zsetAggregate(aggregate string, a float64, b float64) float64 {
+	switch aggregate {
+	case "MIN":
+		return math.Min(a, b)
+	case "MAX":
+		return math.Max(a, b)
+	}
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// zsetOperation parses "numkeys key [key ...] [WEIGHTS ...] [AGGREGATE ...]
+// [WITHSCORES]" and computes the result. WITHSCORES is only accepted when
+// the result is replied, WEIGHTS and AGGREGATE not for ZDIFF.
+func zsetOperation(Args []string, op int, name string, withScoresAllowed bool) (*ZSet, bool, []byte) {
+	numkeys, err := strconv.ParseInt(Args[0], 10, 64)
+	if err != nil {
+		return nil, false, []byte("-ERR value is not an integer or out of range\r\n")
+	}
+	if numkeys < 1 {
+		return nil, false, []byte(fmt.Sprintf("-ERR at least 1 input key is needed for '%s' command\r\n", name))
+	}
+	if numkeys > int64(len(Args)-1) {
+		return nil, false, []byte("-ERR syntax error\r\n")
+	}
+	keys := Args[1 : 1+numkeys]
+	rest := Args[1+numkeys:]
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	withScores := false
+	for i := 0; i < len(rest); i++ {
+		opt := strings.ToUpper(rest[i])
+		switch {
+		case opt == "WEIGHTS" && op != zsetDiff && i+len(keys) < len(rest):
+			for j := range weights {
+				weight, ok := parseFloatArg(rest[i+1+j])
+				if !ok {
+					return nil, false, []byte("-ERR weight value is not a float\r\n")
+				}
+				weights[j] = weight
+			}
+			i += len(keys)
+		case opt == "AGGREGATE" && op != zsetDiff && i+1 < len(rest):
+			aggregate = strings.ToUpper(rest[i+1])
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				return nil, false, []byte("-ERR syntax error\r\n")
+			}
+			i++
+		case opt == "WITHSCORES" && withScoresAllowed:
+			withScores = true
+		default:
+			return nil, false, []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	operands := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		scores, errReply := zsetOperand(key)
+		if errReply != nil {
+			return nil, false, errReply
+		}
+		operands[i] = scores
+	}
+
+	weighted := func(score float64, i int) float64 {
+		if score = score * weights[i]; math.IsNaN(score) {
+			return 0
+		}
+		return score
+	}
+	result := make(map[string]float64)
+	switch op {
+	case zsetUnion:
+		for i, scores := range operands {
+			for member, score := range scores {
+				score = weighted(score, i)
+				if current, ok := result[member]; ok {
+					score = zsetAggregate(aggregate, current, score)
+				}
+				result[member] = score
+			}
+		}
+	case zsetInter:
+		// Walk the smallest input, look the members up in the others
+		order := make([]int, len(operands))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return len(operands[order[a]]) < len(operands[order[b]]) })
+		smallest := order[0]
+	members:
+		for member := range operands[smallest] {
+			var score float64
+			for i, scores := range operands {
+				s, ok := scores[member]
+				if !ok {
+					continue members
+				}
+				if i == 0 {
+					score = weighted(s, i)
+				} else {
+					score = zsetAggregate(aggregate, score, weighted(s, i))
+				}
+			}
+			result[member] = score
+		}
+	case zsetDiff:
+	diff:
+		for member, score := range operands[0] {
+			for _, scores := range operands[1:] {
+				if _, ok := scores[member]; ok {
+					continue diff
+				}
+			}
+			result[member] = score
+		}
+	}
+
+	z := NewZSet()
+	for member, score := range result {
+		z.Add(member, score)
+	}
+	return z, withScores, nil
+}
+
+// zsetOperationReply replies to ZUNION, ZINTER and ZDIFF
+func zsetOperationReply(Args []string, op int, c *Client) []byte {
+	z, withScores, errReply := zsetOperation(Args, op, zsetOpNames[op], true)
+	if errReply != nil {
+		return errReply
+	}
+	return zsetItemsReply(z.RangeByRank(0, z.Len()-1, false), withScores, c)
+}
+
+// zsetOperationStore replies to ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE
+func zsetOperationStore(Args []string, op int, c *Client) []byte {
+	z, _, errReply := zsetOperation(Args[1:], op, zsetOpNames[op]+"store", false)
+	if errReply != nil {
+		return errReply
+	}
+	return storeZSet(Args[0], z, c)
+}
+
+// zstoreKeyPositions finds the keys of CMD destination numkeys key ...
+func zstoreKeyPositions(argv []string) []int {
+	if len(argv) < 3 {
+		return nil
+	}
+	positions := []int{1}
+	for _, pos := range numKeysPositions(argv[1:]) {
+		positions = append(positions, pos+1)
+	}
+	return positions
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func evalZUNION(Args []string, c *Client) []byte {
+	return zsetOperationReply(Args, zsetUnion, c)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func evalZINTER(Args []string, c *Client) []byte {
+	return zsetOperationReply(Args, zsetInter, c)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func evalZDIFF(Args []string, c *Client) []byte {
+	return zsetOperationReply(Args, zsetDiff, c)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func evalZUNIONSTORE(Args []string, c *Client) []byte {
+	return zsetOperationStore(Args, zsetUnion, c)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func evalZINTERSTORE(Args []string, c *Client) []byte {
+	return zsetOperationStore(Args, zsetInter, c)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func evalZDIFFSTORE(Args []string, c *Client) []byte {
+	return zsetOperationStore(Args, zsetDiff, c)
+}
+
+// zremrangeGeneric removes the elements of a ZRANGE style range and
+// replies with how many went
+func zremrangeGeneric(Args []string, by int, c *Client) []byte {
+	items, errReply := zrangeItems(Args[0], Args[1], Args[2], zrangeRequest{by: by, limit: -1})
+	if errReply != nil {
+		return errReply
+	}
+	if len(items) == 0 {
+		return c.addReply(0)
+	}
+	z, _, _ := getZSet(Args[0])
+	for _, item := range items {
+		z.Remove(item.member)
+	}
+	if z.Len() == 0 {
+		Del(Args[0])
+	}
+	return c.addReply(len(items))
+}
+
+// ZREMRANGEBYRANK key start stop
+func evalZREMRANGEBYRANK(Args []string, c *Client) []byte {
+	return zremrangeGeneric(Args, zrangeByRank, c)
+}
+
+// ZREMRANGEBYSCORE key min max
+func evalZREMRANGEBYSCORE(Args []string, c *Client) []byte {
+	return zremrangeGeneric(Args, zrangeByScore, c)
+}
+
+// ZREMRANGEBYLEX key min max
+func evalZREMRANGEBYLEX(Args []string, c *Client) []byte {
+	return zremrangeGeneric(Args, zrangeByLex, c)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func evalZSCAN(Args []string, c *Client) []byte {
+	opts, errReply := parseScanArgs(Args[1:], false, false)
+	if errReply != nil {
+		return errReply
+	}
+	z, _, errReply := getZSet(Args[0])
+	if errReply != nil {
+		return errReply
+	}
+	elements := []string{}
+	if z == nil {
+		return scanReply(0, elements, c)
+	}
+	members, next := z.index.scan(opts.cursor, opts.count)
+	for _, member := range members {
+		if opts.matches(member) {
+			score, _ := z.Score(member)
+			elements = append(elements, member, formatDouble(score))
+		}
+	}
+	return scanReply(next, elements, c)
+}
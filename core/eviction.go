@@ -1,24 +1,291 @@
 package core
 
-import "log"
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
 
-func evictFirst() {
-	log.Printf("Eviction triggered: current store size = %d", len(store))
-	for k := range store {
-		log.Printf("Evicting key: %s", k)
-		delete(store, k)
-		return
+// LFU counter, as in Redis: an 8 bit logarithmic counter that grows more
+// slowly the higher it gets, the more so the higher lfu-log-factor is, and
+// loses one for every lfu-decay-time minutes the object goes without being
+// accessed.
+const lfuInitVal = 5 // new objects start here so they are not evicted at once
+
+// lfuLogFactor is lfu-log-factor: with the default of 10 it takes about a
+// million accesses to saturate the counter
+func lfuLogFactor() float64 {
+	if storeConfig != nil {
+		return float64(storeConfig.LfuLogFactor)
+	}
+	return 10
+}
+
+// lfuDecayTime is lfu-decay-time in minutes, 0 to never decay
+func lfuDecayTime() int64 {
+	if storeConfig != nil {
+		return int64(storeConfig.LfuDecayTime)
+	}
+	return 1
+}
+
+// lfuLogIncr increments counter with a probability that falls as it grows
+func lfuLogIncr(counter uint8) uint8 {
+	if counter == 255 {
+		return 255
+	}
+	baseval := float64(counter) - lfuInitVal
+	if baseval < 0 {
+		baseval = 0
+	}
+	if rand.Float64() < 1.0/(baseval*lfuLogFactor()+1) {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecr returns the counter of obj after applying the decay for the time
+// since its last access
+func lfuDecr(obj *Obj, now int64) uint8 {
+	decayTime := lfuDecayTime()
+	if decayTime == 0 {
+		return obj.Freq
+	}
+	periods := (now - obj.LastAccess) / (60 * 1000) / decayTime
+	if periods >= int64(obj.Freq) {
+		return 0
+	}
+	return obj.Freq - uint8(periods)
+}
+
+// touch records an access to obj
+func (obj *Obj) touch() {
+	now := time.Now().UnixMilli()
+	obj.Freq = lfuLogIncr(lfuDecr(obj, now))
+	obj.LastAccess = now
+}
+
+// Eviction policies, Redis' maxmemory-policy. allkeys-* pick among every
+// key, volatile-* only among keys with an expiry, and noeviction never
+// evicts: once the keys limit is reached, commands that may add data are
+// refused with an OOM error instead.
+const (
+	PolicyNoEviction     = "noeviction"
+	PolicyAllKeysLRU     = "allkeys-lru"
+	PolicyVolatileLRU    = "volatile-lru"
+	PolicyAllKeysLFU     = "allkeys-lfu"
+	PolicyVolatileLFU    = "volatile-lfu"
+	PolicyAllKeysRandom  = "allkeys-random"
+	PolicyVolatileRandom = "volatile-random"
+	PolicyVolatileTTL    = "volatile-ttl"
+)
+
+func isVolatilePolicy(policy string) bool {
+	return strings.HasPrefix(policy, "volatile-")
+}
+
+// isLFUPolicy reports whether the configured policy evicts by access
+// frequency. Redis keeps either the access time or the LFU counter of an
+// object depending on it, and OBJECT only reports the one in use.
+func isLFUPolicy() bool {
+	return storeConfig != nil && strings.HasSuffix(storeConfig.EvictionStrategy, "-lfu")
+}
+
+// evictionNextDb is where the next random eviction starts looking, so the
+// databases take turns like in Redis
+var evictionNextDb int
+
+// evictRandom evicts a random key, only among keys with an expiry when
+// volatile is set, and reports whether there was one
+func evictRandom(volatile bool) bool {
+	for i := 0; i < len(dbs); i++ {
+		d := dbs[evictionNextDb]
+		evictionNextDb = (evictionNextDb + 1) % len(dbs)
+		keys := sampleKeys(d.evictionDict(volatile), 1)
+		if len(keys) == 0 {
+			continue
+		}
+		d.remove(keys[0])
+		return true
+	}
+	return false
+}
+
+// Approximated LRU, LFU and TTL, as in Redis: rather than keeping every key
+// in order, each eviction samples maxmemory-samples keys of every database
+// and offers them to a small pool that survives between evictions and
+// keeps the best candidates seen so far, the best last. For LRU the best
+// is the longest idle key, for LFU the one with the lowest decayed access
+// counter, for TTL the one closest to expiring. The best candidate still
+// in the keyspace is evicted.
+
+const evictionPoolSize = 16
+
+// How the pool ranks candidates
+const (
+	evictByIdle = iota
+	evictByFreq
+	evictByTTL
+)
+
+type evictionPoolEntry struct {
+	// idle is the eviction score when sampled, higher is evicted first:
+	// milliseconds idle for LRU, 255 minus the counter for LFU, minus the
+	// expiry time for TTL
+	idle int64
+	// lastAccess is the access time the key had when sampled: a key read
+	// since then is no longer a candidate
+	lastAccess int64
+	key        string
+	db         *redisDb
+}
+
+// evictionPool is sorted by ascending idle time
+var evictionPool []evictionPoolEntry
+
+func maxmemorySamples() int {
+	if storeConfig != nil && storeConfig.MaxmemorySamples > 0 {
+		return storeConfig.MaxmemorySamples
+	}
+	return 5
+}
+
+// evictionDict is where the keys to evict are picked from: every key, or
+// only those with an expiry when volatile is set
+func (d *redisDb) evictionDict(volatile bool) map[string]*Obj {
+	if volatile {
+		return d.expires
+	}
+	return d.dict
+}
+
+// sampleKeys returns up to n keys of dict. Ranging over a Go map starts at
+// a random position, which stands in for Redis' dictGetSomeKeys.
+func sampleKeys(dict map[string]*Obj, n int) []string {
+	keys := make([]string, 0, n)
+	for key := range dict {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// evictionScore is an object's idle value in the pool for the ranking
+func evictionScore(obj *Obj, now int64, rank int) int64 {
+	switch rank {
+	case evictByFreq:
+		return 255 - int64(lfuDecr(obj, now))
+	case evictByTTL:
+		return -obj.ExpiresAt
 	}
-	log.Println("No keys to evict")
+	return now - obj.LastAccess
 }
 
-func Evict(evictionStrategy string) {
-	log.Printf("Evict called with strategy: %s", evictionStrategy)
-	switch evictionStrategy {
-	case "simple-first":
-		evictFirst()
-	default:
-		evictFirst()
+// evictionPoolPopulate samples keys of d and adds those scoring higher
+// than the pool's worst candidate, dropping that one when the pool is full
+func evictionPoolPopulate(d *redisDb, samples int, now int64, volatile bool, rank int) {
+	dict := d.evictionDict(volatile)
+	for _, key := range sampleKeys(dict, samples) {
+		obj := dict[key]
+		idle := evictionScore(obj, now, rank)
+
+		present := false
+		for _, entry := range evictionPool {
+			if entry.key == key && entry.db == d {
+				present = true
+				break
+			}
+		}
+		if present {
+			continue
+		}
+		i := sort.Search(len(evictionPool), func(i int) bool { return evictionPool[i].idle > idle })
+		if len(evictionPool) == evictionPoolSize {
+			if i == 0 {
+				// Scores lower than everything in the pool
+				continue
+			}
+			// Make room by dropping the worst entry
+			evictionPool = evictionPool[1:]
+			i--
+		}
+		evictionPool = append(evictionPool, evictionPoolEntry{})
+		copy(evictionPool[i+1:], evictionPool[i:])
+		evictionPool[i] = evictionPoolEntry{idle: idle, lastAccess: obj.LastAccess, key: key, db: d}
+	}
+}
+
+// evictFromPool evicts the best candidate for the ranking, among the keys
+// with an expiry when volatile is set, and reports whether there was one
+func evictFromPool(volatile bool, rank int) bool {
+	samples := maxmemorySamples()
+	for hasEvictionCandidates(volatile) {
+		now := time.Now().UnixMilli()
+		for _, d := range dbs {
+			if len(d.evictionDict(volatile)) > 0 {
+				evictionPoolPopulate(d, samples, now, volatile, rank)
+			}
+		}
+		for len(evictionPool) > 0 {
+			entry := evictionPool[len(evictionPool)-1]
+			evictionPool = evictionPool[:len(evictionPool)-1]
+			obj, ok := entry.db.dict[entry.key]
+			if !ok || obj.LastAccess != entry.lastAccess || volatile && obj.ExpiresAt == -1 {
+				// Deleted, replaced, accessed or made persistent since it
+				// was sampled
+				continue
+			}
+			entry.db.remove(entry.key)
+			return true
+		}
+	}
+	return false
+}
+
+// hasEvictionCandidates reports whether any key can be evicted, only
+// counting keys with an expiry when volatile is set
+func hasEvictionCandidates(volatile bool) bool {
+	for _, d := range dbs {
+		if len(d.evictionDict(volatile)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evict removes one key as the policy says and reports whether it could
+func Evict(policy string) bool {
+	switch policy {
+	case PolicyAllKeysLRU, PolicyVolatileLRU:
+		return evictFromPool(isVolatilePolicy(policy), evictByIdle)
+	case PolicyAllKeysLFU, PolicyVolatileLFU:
+		return evictFromPool(isVolatilePolicy(policy), evictByFreq)
+	case PolicyVolatileTTL:
+		return evictFromPool(true, evictByTTL)
+	case PolicyAllKeysRandom, PolicyVolatileRandom:
+		return evictRandom(isVolatilePolicy(policy))
+	}
+	return false
+}
+
+// performEvictions runs before every command, as in Redis: it evicts keys
+// as the policy allows until the keys limit holds with room for newKeys
+// more, the keys the command is about to add. Without new keys this only
+// takes back what an earlier command adding several keys overstepped, so
+// overwriting a key at the limit evicts nothing. It reports false when
+// there is not enough room, in which case commands flagged denyoom are
+// refused while reads and deletions keep working.
+func performEvictions(newKeys int) bool {
+	if storeConfig == nil {
+		return true
+	}
+	for totalKeys()+newKeys > storeConfig.KeysLimit {
+		if !Evict(storeConfig.EvictionStrategy) {
+			return false
+		}
 	}
-	log.Printf("After eviction: store size = %d", len(store))
+	return true
 }
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// age makes every key look ms milliseconds less recently used, standing in
+// for the time passing between the commands of a test. The eviction pool
+// remembers access times too, so its entries age along.
+func age(ms int64) {
+	for _, d := range dbs {
+		for _, obj := range d.dict {
+			obj.LastAccess -= ms
+		}
+	}
+	for i := range evictionPool {
+		evictionPool[i].lastAccess -= ms
+	}
+}
+
+func TestLRUKeepsHotKeys(t *testing.T) {
+	resetServer(t)
+	storeConfig = &StoreConfig{KeysLimit: 100, EvictionStrategy: PolicyAllKeysLRU, MaxmemorySamples: 5, LfuLogFactor: 10, LfuDecayTime: 1}
+	c := NewClient(-1, "", "")
+	for i := 0; i < 100; i++ {
+		run(c, "SET key:"+strconv.Itoa(i)+" v")
+	}
+
+	// The first ten keys are read between the writes of a long run of keys
+	// nobody reads again
+	for i := 0; i < 1000; i++ {
+		age(1000)
+		for j := 0; j < 10; j++ {
+			run(c, "GET key:"+strconv.Itoa(j))
+		}
+		run(c, "SET scan:"+strconv.Itoa(i)+" v")
+	}
+
+	if n := totalKeys(); n != 100 {
+		t.Fatalf("%d keys stored, want the limit of 100", n)
+	}
+	for j := 0; j < 10; j++ {
+		if key := "key:" + strconv.Itoa(j); db.dict[key] == nil {
+			t.Errorf("hot key %s was evicted", key)
+		}
+	}
+}
+
+func TestIntrospectionDoesNotTouch(t *testing.T) {
+	resetServer(t)
+	c := NewClient(-1, "", "")
+	run(c, "SET k v")
+	age(5000)
+	for _, cmd := range []string{"TTL k", "PTTL k", "EXPIRETIME k", "PEXPIRETIME k", "PERSIST k", "EXPIRE k 100", "OBJECT IDLETIME k"} {
+		run(c, cmd)
+		if idle := run(c, "OBJECT IDLETIME k"); idle != ":5\r\n" {
+			t.Errorf("after %s OBJECT IDLETIME replied %q, want :5", cmd, idle)
+		}
+	}
+}
+
+func TestLFUKeepsFrequentKeys(t *testing.T) {
+	resetServer(t)
+	storeConfig = &StoreConfig{KeysLimit: 100, EvictionStrategy: PolicyAllKeysLFU, MaxmemorySamples: 5, LfuLogFactor: 10, LfuDecayTime: 1}
+	c := NewClient(-1, "", "")
+	for i := 0; i < 100; i++ {
+		run(c, "SET key:"+strconv.Itoa(i)+" v")
+	}
+	// The first ten keys are the hot set
+	for n := 0; n < 1000; n++ {
+		for j := 0; j < 10; j++ {
+			run(c, "GET key:"+strconv.Itoa(j))
+		}
+	}
+
+	// A scan reads every key of a long run once. Now and then the hot
+	// keys are written, which must not reset their counters.
+	for i := 0; i < 1000; i++ {
+		scan := "scan:" + strconv.Itoa(i)
+		run(c, "SET "+scan+" v")
+		run(c, "GET "+scan)
+		if i%100 == 0 {
+			for j := 0; j < 10; j++ {
+				key := "key:" + strconv.Itoa(j)
+				before := db.dict[key].Freq
+				run(c, "SET "+key+" w")
+				if after := db.dict[key].Freq; after < before {
+					t.Fatalf("SET %s dropped its counter from %d to %d", key, before, after)
+				}
+			}
+		}
+	}
+
+	if n := totalKeys(); n != 100 {
+		t.Fatalf("%d keys stored, want the limit of 100", n)
+	}
+	for j := 0; j < 10; j++ {
+		if key := "key:" + strconv.Itoa(j); db.dict[key] == nil {
+			t.Errorf("frequently used key %s was evicted", key)
+		}
+	}
+}
+
+func TestOOMRefusal(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		volatile bool // whether key a has an expiry
+		cmd      string
+		want     string
+		evicted  string // key evicted to make room
+	}{
+		{"noeviction refuses a new key", PolicyNoEviction, false, "SET d 4", string(RESP_OOM), ""},
+		{"noeviction refuses with expiring keys too", PolicyNoEviction, true, "SET d 4", string(RESP_OOM), ""},
+		{"noeviction refuses MSET with one new key", PolicyNoEviction, false, "MSET a 5 d 4", string(RESP_OOM), ""},
+		{"noeviction takes an overwrite at the limit", PolicyNoEviction, false, "SET a 5", "+OK\r\n", ""},
+		{"noeviction takes INCR of an existing key", PolicyNoEviction, false, "INCR a", ":2\r\n", ""},
+		{"volatile-lru without expiring keys takes an overwrite", PolicyVolatileLRU, false, "APPEND a x", ":2\r\n", ""},
+		{"noeviction keeps reads working", PolicyNoEviction, false, "GET a", "$1\r\n1\r\n", ""},
+		{"noeviction keeps deletions working", PolicyNoEviction, false, "DEL a", ":1\r\n", ""},
+		{"arity is checked before memory", PolicyNoEviction, false, "SET d", "-ERR wrong number of arguments for 'set' command\r\n", ""},
+		{"volatile-lru without expiring keys refuses", PolicyVolatileLRU, false, "SET d 4", string(RESP_OOM), ""},
+		{"volatile-lfu without expiring keys refuses", PolicyVolatileLFU, false, "SADD d x", string(RESP_OOM), ""},
+		{"volatile-random without expiring keys refuses", PolicyVolatileRandom, false, "LPUSH d x", string(RESP_OOM), ""},
+		{"volatile-ttl without expiring keys refuses", PolicyVolatileTTL, false, "SET d 4", string(RESP_OOM), ""},
+		{"volatile-ttl still takes expiries", PolicyVolatileTTL, false, "EXPIRE a 100", ":1\r\n", ""},
+		{"volatile-lru evicts the expiring key", PolicyVolatileLRU, true, "SET d 4", "+OK\r\n", "a"},
+		{"volatile-random evicts the expiring key", PolicyVolatileRandom, true, "SET d 4", "+OK\r\n", "a"},
+		{"volatile-ttl evicts the expiring key", PolicyVolatileTTL, true, "SET d 4", "+OK\r\n", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			c := NewClient(-1, "", "")
+			run(c, "SET a 1")
+			run(c, "SET b 2")
+			run(c, "SET c 3")
+			if tt.volatile {
+				run(c, "EXPIRE a 100")
+			}
+			storeConfig = &StoreConfig{KeysLimit: 3, EvictionStrategy: tt.policy, MaxmemorySamples: 5, LfuLogFactor: 10, LfuDecayTime: 1}
+
+			if got := run(c, tt.cmd); got != tt.want {
+				t.Fatalf("%s replied %q, want %q", tt.cmd, got, tt.want)
+			}
+			if tt.want == string(RESP_OOM) && totalKeys() != 3 {
+				t.Errorf("%d keys stored after the refusal, want 3", totalKeys())
+			}
+			if tt.evicted != "" && db.dict[tt.evicted] != nil {
+				t.Errorf("%s was not evicted", tt.evicted)
+			}
+		})
+	}
+}
+
+func TestKeysLimitHolds(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []string // leaves three keys, the limit
+		cmd   string
+		want  string
+	}{
+		{"SMOVE creating its destination", []string{"SADD s x y", "SET a 1", "SET b 2"}, "SMOVE s dst x", ":1\r\n"},
+		{"MSET adding several keys", []string{"SET a 1", "SET b 2", "SET c 3"}, "MSET d 4 e 5 f 6", "+OK\r\n"},
+		{"INCR of an existing key evicts nothing", []string{"SET a 1", "SET b 2", "SET c 3"}, "INCR a", ":2\r\n"},
+		{"SET over an existing key evicts nothing", []string{"SET a 1", "SET b 2", "SET c 3"}, "SET b 5", "+OK\r\n"},
+		{"MSET of existing keys evicts nothing", []string{"SET a 1", "SET b 2", "SET c 3"}, "MSET a 5 b 6 c 7", "+OK\r\n"},
+		{"MOVE to another database", []string{"SET a 1", "SET b 2", "SET c 3"}, "MOVE a 1", ":1\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			createDbs(2)
+			c := NewClient(-1, "", "")
+			for _, cmd := range tt.setup {
+				run(c, cmd)
+			}
+			storeConfig = &StoreConfig{KeysLimit: 3, EvictionStrategy: PolicyAllKeysRandom}
+
+			if got := run(c, tt.cmd); got != tt.want {
+				t.Fatalf("%s replied %q, want %q", tt.cmd, got, tt.want)
+			}
+			run(c, "PING")
+			if n := totalKeys(); n != 3 {
+				t.Errorf("%d keys stored after the next command, want the limit of 3", n)
+			}
+		})
+	}
+}
+
+func TestAccessHistoryOnReplace(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		wantFreq uint8 // counter of dst afterwards
+		wantIdle bool  // whether dst keeps the idle time src or dst had
+	}{
+		{"SET keeps the counter of the value it replaces", "SET dst v", 100, false},
+		{"INCR keeps the counter of the value it replaces", "INCR dst", 100, false},
+		{"RENAME keeps the history of the renamed key", "RENAME src dst", 200, true},
+		{"SINTERSTORE stores a new key", "SINTERSTORE dst s", lfuInitVal, false},
+		{"COPY REPLACE stores a new key", "COPY src dst REPLACE", lfuInitVal, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetServer(t)
+			storeConfig = &StoreConfig{KeysLimit: 100, EvictionStrategy: PolicyAllKeysLFU, MaxmemorySamples: 5, LfuLogFactor: 10, LfuDecayTime: 0}
+			c := NewClient(-1, "", "")
+			run(c, "SET src 1")
+			run(c, "SET dst 2")
+			run(c, "SADD s x")
+			db.dict["src"].Freq = 200
+			db.dict["dst"].Freq = 100
+			age(5000)
+
+			run(c, tt.cmd)
+			obj := db.dict["dst"]
+			if obj == nil {
+				t.Fatalf("%s left no dst", tt.cmd)
+			}
+			// The lookup of the old value may count as one more access
+			if obj.Freq != tt.wantFreq && obj.Freq != tt.wantFreq+1 {
+				t.Errorf("dst has counter %d, want %d", obj.Freq, tt.wantFreq)
+			}
+			if idle := time.Now().UnixMilli() - obj.LastAccess; (idle >= 5000) != tt.wantIdle {
+				t.Errorf("dst idle for %dms", idle)
+			}
+		})
+	}
+}
@@ -5,36 +5,43 @@ import (
 	"time"
 )
 
-func expireSample() float32 {
+func expireSample(d *redisDb) float32 {
 	//a sample size is 20
 	var limit int = 20
 	var expiredCnt int = 0
 
-	for key, obj := range store {
-		if obj.ExpiresAt != -1 {
-			limit--
-			if obj.ExpiresAt <= time.Now().UnixMilli() {
-				delete(store, key)
-				expiredCnt++
-			}
+	for key, obj := range d.expires {
+		limit--
+		if obj.ExpiresAt <= time.Now().UnixMilli() {
+			d.remove(key)
+			expiredCnt++
 		}
 		if limit == 0 {
 			break
 		}
 	}
-	return float32(expiredCnt) / float32(limit)
+	// The share of the sampled keys that had expired; a database without
+	// volatile keys has nothing to sample
+	sampled := 20 - limit
+	if sampled == 0 {
+		return 0
+	}
+	return float32(expiredCnt) / float32(sampled)
 }
 
 func DeleteExpireKeys() {
 	// Sampling approach: https://redis.io/commands/expire/
 	//delte a sample and if the number of delete from the sample is more than 25% delete again from a new sample
 
-	for {
-		frac := expireSample()
+	// Each database is sampled on its own
+	for _, d := range dbs {
+		for {
+			frac := expireSample(d)
 
-		if frac < 0.25 {
-			break
+			if frac < 0.25 {
+				break
+			}
 		}
 	}
-	log.Println("Deleted the expired Keys. total keys ", len(store))
+	log.Println("Deleted the expired Keys. total keys ", totalKeys())
 }
@@ -0,0 +1,99 @@
+package core
+
+// globMatch reports whether s matches the glob-style pattern the way Redis'
+// stringmatchlen does for KEYS and the SCAN family: * and ? wildcards,
+// [abc], [^abc] and [a-z] classes, and \ to escape the next character.
+func globMatch(pattern string, s string) bool {
+	skipLongerMatches := false
+	return globMatchImpl(pattern, s, &skipLongerMatches, 0)
+}
+
+// globMaxNesting bounds the recursion on *, as Redis does
+const globMaxNesting = 1000
+
+// globMatchImpl is stringmatchlen_impl. Once the rest of the pattern after
+// a * fails to match any suffix of s, no earlier * can make it match by
+// consuming more, so skipLongerMatches cuts the search short instead of
+// backtracking through every combination.
+func globMatchImpl(pattern string, s string, skipLongerMatches *bool, nesting int) bool {
+	if nesting > globMaxNesting {
+		return false
+	}
+	p, i := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for j := i; j <= len(s); j++ {
+				if globMatchImpl(pattern[p+1:], s[j:], skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			if i == len(s) {
+				return false
+			}
+			i++
+		case '[':
+			if i == len(s) {
+				return false
+			}
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for p < len(pattern) && pattern[p] != ']' {
+				if pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == s[i] {
+						match = true
+					}
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[i] >= start && s[i] <= end {
+						match = true
+					}
+					p += 2
+				} else if pattern[p] == s[i] {
+					match = true
+				}
+				p++
+			}
+			// an unterminated class ends at the end of the pattern
+			if p == len(pattern) {
+				p--
+			}
+			if match == not {
+				return false
+			}
+			i++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if i == len(s) || pattern[p] != s[i] {
+				return false
+			}
+			i++
+		}
+		p++
+	}
+	return i == len(s)
+}
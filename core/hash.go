@@ -0,0 +1,167 @@
+package core
+
+import "math/rand"
+
+// Hash keeps small hashes as a flat slice of field/value pairs, scanned
+// linearly like a Redis listpack, and moves them to a Go map once they
+// grow past hash-max-listpack-entries or hold a field or value longer than
+// hash-max-listpack-value. A hash never converts back.
+type Hash struct {
+	entries []hashEntry       // compact form, in insertion order
+	table   map[string]string // nil while the hash is compact
+	index   scanIndex         // the fields of table, for HSCAN
+}
+
+type hashEntry struct {
+	field string
+	value string
+}
+
+func NewHash() *Hash {
+	return &Hash{}
+}
+
+func (h *Hash) IsCompact() bool {
+	return h.table == nil
+}
+
+func (h *Hash) Len() int {
+	if h.table != nil {
+		return len(h.table)
+	}
+	return len(h.entries)
+}
+
+func (h *Hash) find(field string) int {
+	for i := range h.entries {
+		if h.entries[i].field == field {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	if h.table != nil {
+		value, ok := h.table[field]
+		return value, ok
+	}
+	if i := h.find(field); i >= 0 {
+		return h.entries[i].value, true
+	}
+	return "", false
+}
+
+// Set stores value under field and reports whether the field is new
+func (h *Hash) Set(field string, value string) bool {
+	if h.table == nil && (len(field) > hashMaxListpackValue() || len(value) > hashMaxListpackValue()) {
+		h.convert()
+	}
+	if h.table != nil {
+		_, exists := h.table[field]
+		h.table[field] = value
+		if !exists {
+			h.index.add(field)
+		}
+		return !exists
+	}
+	if i := h.find(field); i >= 0 {
+		h.entries[i].value = value
+		return false
+	}
+	h.entries = append(h.entries, hashEntry{field, value})
+	if len(h.entries) > hashMaxListpackEntries() {
+		h.convert()
+	}
+	return true
+}
+
+// Delete removes field and reports whether it was there
+func (h *Hash) Delete(field string) bool {
+	if h.table != nil {
+		_, exists := h.table[field]
+		if exists {
+			delete(h.table, field)
+			h.index.remove(field)
+		}
+		return exists
+	}
+	i := h.find(field)
+	if i < 0 {
+		return false
+	}
+	h.entries = append(h.entries[:i], h.entries[i+1:]...)
+	return true
+}
+
+// Each calls fn for every field, in insertion order while compact
+func (h *Hash) Each(fn func(field string, value string)) {
+	if h.table != nil {
+		for field, value := range h.table {
+			fn(field, value)
+		}
+		return
+	}
+	for _, entry := range h.entries {
+		fn(entry.field, entry.value)
+	}
+}
+
+// convert moves the fields to the map representation
+func (h *Hash) convert() {
+	h.table = make(map[string]string, len(h.entries))
+	for _, entry := range h.entries {
+		h.table[entry.field] = entry.value
+		h.index.add(entry.field)
+	}
+	h.entries = nil
+}
+
+func hashMaxListpackEntries() int {
+	if storeConfig != nil {
+		return storeConfig.HashMaxListpackEntries
+	}
+	return 128
+}
+
+func hashMaxListpackValue() int {
+	if storeConfig != nil {
+		return storeConfig.HashMaxListpackValue
+	}
+	return 64
+}
+
+// Dup returns a copy of the hash in the same representation
+func (h *Hash) Dup() *Hash {
+	dup := &Hash{entries: append([]hashEntry(nil), h.entries...)}
+	if h.table != nil {
+		dup.table = make(map[string]string, len(h.table))
+		for field, value := range h.table {
+			dup.table[field] = value
+		}
+		dup.index = h.index.dup()
+	}
+	return dup
+}
+
+// Random returns a field and its value picked at random; the hash must not
+// be empty. A compact hash picks an index. A map has no index, so like
+// dictGetFairRandomKey in Redis it walks a few fields from a random place,
+// where the runtime starts iterating it, and picks one of those. Picking
+// the first would favour the fields after the empty slots of the map.
+func (h *Hash) Random() (string, string) {
+	if h.table != nil {
+		var fields [getFairRandomEntries]string
+		n := 0
+		for field := range h.table {
+			fields[n] = field
+			if n++; n == len(fields) {
+				break
+			}
+		}
+		field := fields[rand.Intn(n)]
+		return field, h.table[field]
+	}
+	entry := h.entries[rand.Intn(len(h.entries))]
+	return entry.field, entry.value
+}
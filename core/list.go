@@ -0,0 +1,196 @@
+package core
+
+// List is a double ended queue over a ring buffer: pushes and pops at both
+// ends are O(1) amortised and indexing is O(1), while inserting or removing
+// in the middle shifts the elements in between.
+type List struct {
+	buf  []string
+	head int // index in buf of the first element
+	size int
+	// bytes is the total length of the elements, used to pick the encoding
+	bytes int
+}
+
+const listMinCap = 8
+
+func NewList() *List {
+	return &List{buf: make([]string, listMinCap)}
+}
+
+func (l *List) Len() int {
+	return l.size
+}
+
+// at maps a position in the list to an index in buf
+func (l *List) at(i int) int {
+	return (l.head + i) % len(l.buf)
+}
+
+func (l *List) resize(capacity int) {
+	buf := make([]string, capacity)
+	for i := 0; i < l.size; i++ {
+		buf[i] = l.buf[l.at(i)]
+	}
+	l.buf = buf
+	l.head = 0
+}
+
+func (l *List) grow() {
+	if l.size == len(l.buf) {
+		l.resize(len(l.buf) * 2)
+	}
+}
+
+// shrink gives memory back once the list is mostly empty
+func (l *List) shrink() {
+	if len(l.buf) > listMinCap && l.size <= len(l.buf)/4 {
+		l.resize(max(len(l.buf)/2, listMinCap))
+	}
+}
+
+func (l *List) PushFront(value string) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = value
+	l.size++
+	l.bytes += len(value)
+}
+
+func (l *List) PushBack(value string) {
+	l.grow()
+	l.buf[l.at(l.size)] = value
+	l.size++
+	l.bytes += len(value)
+}
+
+// PopFront removes and returns the first element, the list must not be empty
+func (l *List) PopFront() string {
+	value := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = (l.head + 1) % len(l.buf)
+	l.size--
+	l.bytes -= len(value)
+	l.shrink()
+	return value
+}
+
+// PopBack removes and returns the last element, the list must not be empty
+func (l *List) PopBack() string {
+	i := l.at(l.size - 1)
+	value := l.buf[i]
+	l.buf[i] = ""
+	l.size--
+	l.bytes -= len(value)
+	l.shrink()
+	return value
+}
+
+// Index returns the element at position i, 0 <= i < Len()
+func (l *List) Index(i int) string {
+	return l.buf[l.at(i)]
+}
+
+// Set replaces the element at position i, 0 <= i < Len()
+func (l *List) Set(i int, value string) {
+	j := l.at(i)
+	l.bytes += len(value) - len(l.buf[j])
+	l.buf[j] = value
+}
+
+// Insert puts value at position i, 0 <= i <= Len(), moving the elements
+// from i on one place to the right
+func (l *List) Insert(i int, value string) {
+	l.grow()
+	for j := l.size; j > i; j-- {
+		l.buf[l.at(j)] = l.buf[l.at(j-1)]
+	}
+	l.buf[l.at(i)] = value
+	l.size++
+	l.bytes += len(value)
+}
+
+// Remove deletes the element at position i, 0 <= i < Len()
+func (l *List) Remove(i int) {
+	l.bytes -= len(l.Index(i))
+	for j := i; j < l.size-1; j++ {
+		l.buf[l.at(j)] = l.buf[l.at(j+1)]
+	}
+	l.buf[l.at(l.size-1)] = ""
+	l.size--
+	l.shrink()
+}
+
+// Range returns the elements from start to end, both inclusive and already
+// clamped to the list
+func (l *List) Range(start int, end int) []string {
+	result := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		result = append(result, l.Index(i))
+	}
+	return result
+}
+
+// Trim keeps only the elements from start to end, both inclusive; an empty
+// range (start > end) empties the list
+func (l *List) Trim(start int, end int) {
+	kept := []string{}
+	if start <= end {
+		kept = l.Range(start, end)
+	}
+	l.reset(kept)
+}
+
+// RemoveMatching deletes up to count elements equal to value, scanning from
+// the head, or from the tail when count is negative; 0 removes them all.
+// It returns how many were removed.
+func (l *List) RemoveMatching(value string, count int64) int64 {
+	removed := int64(0)
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	drop := make([]bool, l.size)
+	for n := 0; n < l.size; n++ {
+		i := n
+		if count < 0 {
+			i = l.size - 1 - n
+		}
+		if l.Index(i) == value {
+			drop[i] = true
+			removed++
+			if limit > 0 && removed == limit {
+				break
+			}
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	kept := make([]string, 0, l.size-int(removed))
+	for i := 0; i < l.size; i++ {
+		if !drop[i] {
+			kept = append(kept, l.Index(i))
+		}
+	}
+	l.reset(kept)
+	return removed
+}
+
+// reset replaces the content of the list with values
+func (l *List) reset(values []string) {
+	l.buf = make([]string, max(len(values), listMinCap))
+	copy(l.buf, values)
+	l.head = 0
+	l.size = len(values)
+	l.bytes = 0
+	for _, value := range values {
+		l.bytes += len(value)
+	}
+}
+
+// Dup returns a copy of the list
+func (l *List) Dup() *List {
+	dup := *l
+	dup.buf = append([]string(nil), l.buf...)
+	return &dup
+}
@@ -0,0 +1,177 @@
+package core
+
+import "math"
+
+// Rough sizes of the structures Redis would allocate on a 64 bit build.
+// MEMORY USAGE reports what a value would cost in Redis rather than what
+// the Go runtime happens to use, which is close enough to compare keys and
+// spot the big ones.
+const (
+	robjSize      = 16 // the object header
+	dictEntrySize = 24 // one slot of the keyspace or of a hash table
+	dictSize      = 56 // a dict with its two tables
+	pointerSize   = 8
+	listpackHdr   = 7 // total bytes, entry count and terminator
+	quicklistSize = 40
+	quicklistNode = 32
+	zskiplistSize = 32 // header and tail pointers, length and level
+)
+
+// zskiplistNodeSize is a skiplist node: member pointer, score, backward
+// pointer and a forward pointer and span per level
+func zskiplistNodeSize(levels int) int64 {
+	return 3*pointerSize + int64(levels)*16
+}
+
+// sdsSize is the allocation of a string of n bytes: header, payload and the
+// terminating zero
+func sdsSize(n int) int64 {
+	switch {
+	case n < 1<<8:
+		return int64(n) + 3 + 1
+	case n < 1<<16:
+		return int64(n) + 5 + 1
+	case n < 1<<32:
+		return int64(n) + 9 + 1
+	}
+	return int64(n) + 17 + 1
+}
+
+// listpackEntrySize is a listpack entry: encoding byte(s), data and backlen
+func listpackEntrySize(s string) int64 {
+	if _, ok := parseStrictInt(s); ok {
+		return 1 + 8 + 1
+	}
+	if len(s) < 64 {
+		return int64(len(s)) + 1 + 1
+	}
+	return int64(len(s)) + 5 + 5
+}
+
+// dictBuckets is the bucket array of a dict holding n entries
+func dictBuckets(n int) int64 {
+	size := 4
+	for size < n {
+		size *= 2
+	}
+	return int64(size) * pointerSize
+}
+
+// sampled estimates the total of n elements from the first ones: sizeOf is
+// called for at most samples of them (all of them when samples is 0) and
+// the average is scaled to n
+func sampled(n int, samples int, each func(yield func(size int64) bool)) int64 {
+	if n == 0 {
+		return 0
+	}
+	var total int64
+	seen := 0
+	each(func(size int64) bool {
+		total += size
+		seen++
+		return samples == 0 || seen < samples
+	})
+	if seen == 0 {
+		return 0
+	}
+	return total / int64(seen) * int64(n)
+}
+
+// objMemoryUsage estimates the bytes used by key and its value, looking at
+// samples elements of aggregate values (0 for all of them)
+func objMemoryUsage(key string, obj *Obj, samples int) int64 {
+	size := int64(dictEntrySize) + sdsSize(len(key))
+	if obj.ExpiresAt != -1 {
+		// the entry in the expires dict
+		size += dictEntrySize
+	}
+
+	switch obj.Type {
+	case ObjString:
+		// int values live in the object header itself, embstr ones share
+		// its allocation, which costs the same in total as raw
+		size += robjSize
+		if obj.Encoding != EncInt {
+			size += sdsSize(len(objString(obj)))
+		}
+	case ObjList:
+		l := obj.Value.(*List)
+		size += robjSize + quicklistSize
+		size += sampled(l.Len(), samples, func(yield func(int64) bool) {
+			for i := 0; i < l.Len(); i++ {
+				if !yield(listpackEntrySize(l.Index(i))) {
+					return
+				}
+			}
+		})
+		nodes := int64(1)
+		if obj.Encoding == EncQuicklist {
+			nodes = int64(l.bytes/listMaxListpackSize + 1)
+		}
+		size += nodes * (quicklistNode + listpackHdr)
+	case ObjHash:
+		h := obj.Value.(*Hash)
+		size += robjSize
+		if h.IsCompact() {
+			size += listpackHdr
+			size += sampled(h.Len(), samples, func(yield func(int64) bool) {
+				for _, entry := range h.entries {
+					if !yield(listpackEntrySize(entry.field) + listpackEntrySize(entry.value)) {
+						return
+					}
+				}
+			})
+		} else {
+			size += dictSize + dictBuckets(h.Len())
+			size += sampled(h.Len(), samples, func(yield func(int64) bool) {
+				for field, value := range h.table {
+					if !yield(dictEntrySize + sdsSize(len(field)) + sdsSize(len(value))) {
+						return
+					}
+				}
+			})
+		}
+	case ObjSet:
+		set := obj.Value.(*Set)
+		size += robjSize
+		if set.IsIntset() {
+			size += 8 + int64(set.Len())*intsetWidth(set.ints)
+		} else {
+			size += dictSize + dictBuckets(set.Len())
+			size += sampled(set.Len(), samples, func(yield func(int64) bool) {
+				for member := range set.table {
+					if !yield(dictEntrySize + sdsSize(len(member))) {
+						return
+					}
+				}
+			})
+		}
+	case ObjZSet:
+		zset := obj.Value.(*ZSet)
+		size += robjSize + dictSize + dictBuckets(zset.Len()) + zskiplistSize
+		size += sampled(zset.Len(), samples, func(yield func(int64) bool) {
+			for x := zset.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+				// The member is shared by the dict entry and the node
+				if !yield(dictEntrySize + sdsSize(len(x.member)) + zskiplistNodeSize(len(x.level))) {
+					return
+				}
+			}
+		})
+	}
+	return size
+}
+
+// intsetWidth is the bytes per member of an intset: the smallest of 2, 4
+// or 8 that fits every member
+func intsetWidth(ints []int64) int64 {
+	width := int64(2)
+	for _, n := range ints {
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return 8
+		}
+		if n < math.MinInt16 || n > math.MaxInt16 {
+			width = 4
+		}
+	}
+	return width
+}
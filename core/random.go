@@ -0,0 +1,49 @@
+package core
+
+import "math/rand"
+
+// getFairRandomEntries is how many elements Hash.Random and Set.Random walk
+// in a map to pick one from, as GETFAIR_NUM_ENTRIES in Redis
+const getFairRandomEntries = 15
+
+// sampleDistinct picks count distinct elements at random out of a
+// collection of n without copying it: random returns one element at random
+// and each walks all of them. Like SRANDMEMBER in Redis, a sample that is a
+// large part of the collection is taken in one walk (with a reservoir here),
+// while a small one is drawn at random until enough distinct elements came
+// up.
+func sampleDistinct(n int, count int, random func() string, each func(fn func(string) bool)) []string {
+	if count >= n {
+		all := make([]string, 0, n)
+		each(func(e string) bool {
+			all = append(all, e)
+			return true
+		})
+		return all
+	}
+	if count*3 > n {
+		reservoir := make([]string, 0, count)
+		seen := 0
+		each(func(e string) bool {
+			if len(reservoir) < count {
+				reservoir = append(reservoir, e)
+			} else if j := rand.Intn(seen + 1); j < count {
+				reservoir[j] = e
+			}
+			seen++
+			return true
+		})
+		// The first elements walked sit in order, a sorted intset shows it
+		rand.Shuffle(len(reservoir), func(i, j int) { reservoir[i], reservoir[j] = reservoir[j], reservoir[i] })
+		return reservoir
+	}
+	picked := make(map[string]bool, count)
+	sample := make([]string, 0, count)
+	for len(sample) < count {
+		if e := random(); !picked[e] {
+			picked[e] = true
+			sample = append(sample, e)
+		}
+	}
+	return sample
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// The SCAN family uses the reverse binary cursor of Redis' dictScan. Go
+// maps cannot be walked from a given position, so every map backed
+// collection keeps its names in a scanIndex as well: a hash table of a
+// power of two buckets, the bucket being the low bits of the 64 bit FNV-1a
+// hash of the name, updated along with the map. The cursor is the next
+// bucket to visit. It is advanced by incrementing its bits from the most
+// significant one down, so when the table doubles or halves between calls
+// the buckets already visited map to buckets that are behind the cursor in
+// the new table too. Elements that are there for the whole iteration are
+// therefore returned at least once (some may come twice after a shrink)
+// whatever is added or removed meanwhile, and a call only costs the
+// buckets it visits.
+
+// scanHash is FNV-1a, 64 bit
+func scanHash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+type scanItem struct {
+	hash uint64
+	name string
+}
+
+// scanMinBuckets is the smallest table, like Redis' smallest dict
+const scanMinBuckets = 4
+
+// scanIndex holds the names of a collection in hash buckets for SCAN. The
+// table doubles when there are as many names as buckets and shrinks when
+// it is less than an eighth full; the hashes are kept so that resizing
+// does not hash every name again. The zero value is an empty index.
+type scanIndex struct {
+	buckets [][]scanItem // a power of two of them, or none while empty
+	n       int
+}
+
+func (ix *scanIndex) resize(size int) {
+	buckets := make([][]scanItem, size)
+	mask := uint64(size - 1)
+	for _, bucket := range ix.buckets {
+		for _, item := range bucket {
+			buckets[item.hash&mask] = append(buckets[item.hash&mask], item)
+		}
+	}
+	ix.buckets = buckets
+}
+
+// add inserts name, which must not be in the index yet
+func (ix *scanIndex) add(name string) {
+	if ix.n >= len(ix.buckets) {
+		ix.resize(max(2*len(ix.buckets), scanMinBuckets))
+	}
+	h := scanHash(name)
+	b := h & uint64(len(ix.buckets)-1)
+	ix.buckets[b] = append(ix.buckets[b], scanItem{h, name})
+	ix.n++
+}
+
+// remove deletes name if it is in the index
+func (ix *scanIndex) remove(name string) {
+	if ix.n == 0 {
+		return
+	}
+	b := scanHash(name) & uint64(len(ix.buckets)-1)
+	bucket := ix.buckets[b]
+	for i := range bucket {
+		if bucket[i].name != name {
+			continue
+		}
+		last := len(bucket) - 1
+		bucket[i] = bucket[last]
+		bucket[last] = scanItem{}
+		if last == 0 {
+			ix.buckets[b] = nil
+		} else {
+			ix.buckets[b] = bucket[:last]
+		}
+		ix.n--
+		break
+	}
+	if len(ix.buckets) > scanMinBuckets && ix.n*8 < len(ix.buckets) {
+		size := scanMinBuckets
+		for size < 2*ix.n {
+			size *= 2
+		}
+		ix.resize(size)
+	}
+}
+
+// dup returns a copy of the index
+func (ix *scanIndex) dup() scanIndex {
+	dup := scanIndex{buckets: make([][]scanItem, len(ix.buckets)), n: ix.n}
+	for i, bucket := range ix.buckets {
+		dup.buckets[i] = append([]scanItem(nil), bucket...)
+	}
+	return dup
+}
+
+// scan visits buckets from cursor on until about count names were found,
+// or ten times count empty buckets were seen, and returns them with the
+// cursor of the next call (0 once every bucket was visited). Names in the
+// same bucket always come back in the same call.
+func (ix *scanIndex) scan(cursor uint64, count int) ([]string, uint64) {
+	names := []string{}
+	if ix.n == 0 {
+		return names, 0
+	}
+	mask := uint64(len(ix.buckets) - 1)
+	emptyVisits := count * 10
+	v := cursor
+	for {
+		bucket := ix.buckets[v&mask]
+		for _, item := range bucket {
+			names = append(names, item.name)
+		}
+		if len(bucket) == 0 {
+			emptyVisits--
+		}
+		// Increment the reversed cursor: set the bits above the mask so
+		// the carry goes past them, reverse, add one, reverse back
+		v |= ^mask
+		v = bits.Reverse64(bits.Reverse64(v) + 1)
+		if v == 0 || len(names) >= count || emptyVisits <= 0 {
+			return names, v
+		}
+	}
+}
+
+// scanOptions are the arguments shared by SCAN, HSCAN, SSCAN and ZSCAN
+type scanOptions struct {
+	cursor   uint64
+	count    int
+	pattern  string // empty matches everything
+	objType  string // SCAN's TYPE filter
+	noValues bool   // HSCAN's NOVALUES
+}
+
+// parseScanArgs parses "cursor [MATCH pattern] [COUNT count] ...". TYPE is
+// only accepted when allowType is set and NOVALUES when allowNoValues is.
+func parseScanArgs(Args []string, allowType bool, allowNoValues bool) (scanOptions, []byte) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(Args[0], 10, 64)
+	if err != nil {
+		return opts, []byte("-ERR invalid cursor\r\n")
+	}
+	opts.cursor = cursor
+	for i := 1; i < len(Args); i++ {
+		opt := strings.ToUpper(Args[i])
+		switch {
+		case opt == "COUNT" && i+1 < len(Args):
+			n, err := strconv.ParseInt(Args[i+1], 10, 64)
+			if err != nil {
+				return opts, []byte("-ERR value is not an integer or out of range\r\n")
+			}
+			if n < 1 {
+				return opts, []byte("-ERR syntax error\r\n")
+			}
+			opts.count = int(min(n, math.MaxInt32))
+			i++
+		case opt == "MATCH" && i+1 < len(Args):
+			opts.pattern = Args[i+1]
+			if opts.pattern == "*" {
+				opts.pattern = ""
+			}
+			i++
+		case opt == "TYPE" && allowType && i+1 < len(Args):
+			opts.objType = strings.ToLower(Args[i+1])
+			i++
+		case opt == "NOVALUES" && allowNoValues:
+			opts.noValues = true
+		default:
+			return opts, []byte("-ERR syntax error\r\n")
+		}
+	}
+	return opts, nil
+}
+
+// matches applies the MATCH pattern
+func (opts scanOptions) matches(name string) bool {
+	return opts.pattern == "" || globMatch(opts.pattern, name)
+}
+
+// scanReply builds the two element reply: the next cursor and the elements
+func scanReply(cursor uint64, elements []string, c *Client) []byte {
+	return c.addReply([]interface{}{strconv.FormatUint(cursor, 10), elements})
+}
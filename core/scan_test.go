@@ -0,0 +1,90 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestScanIndexReturnsEveryStableName(t *testing.T) {
+	tests := []struct {
+		name    string
+		stable  int // names there for the whole iteration
+		added   int // names added during it, 50 per call
+		removed int // of the added ones, removed again later on
+	}{
+		{"unchanged", 1000, 0, 0},
+		{"growing", 100, 5000, 0},
+		{"growing then shrinking", 100, 5000, 5000},
+		{"empty", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ix scanIndex
+			for i := 0; i < tt.stable; i++ {
+				ix.add("stable:" + strconv.Itoa(i))
+			}
+			seen := make(map[string]bool)
+			added, removed := 0, 0
+			cursor := uint64(0)
+			for {
+				names, next := ix.scan(cursor, 10)
+				for _, name := range names {
+					seen[name] = true
+				}
+				// Grow by many names per call so the table resizes a few
+				// times mid iteration, then drop them again
+				for i := 0; i < 50 && added < tt.added; i++ {
+					ix.add("added:" + strconv.Itoa(added))
+					added++
+				}
+				if added == tt.added {
+					for i := 0; i < 100 && removed < tt.removed; i++ {
+						ix.remove("added:" + strconv.Itoa(removed))
+						removed++
+					}
+				}
+				cursor = next
+				if cursor == 0 {
+					break
+				}
+			}
+			for i := 0; i < tt.stable; i++ {
+				if name := "stable:" + strconv.Itoa(i); !seen[name] {
+					t.Errorf("%s was never returned", name)
+				}
+			}
+			if want := tt.stable + added - removed; ix.n != want {
+				t.Errorf("index holds %d names, want %d", ix.n, want)
+			}
+		})
+	}
+}
+
+func TestScanIndexRemove(t *testing.T) {
+	var ix scanIndex
+	for i := 0; i < 1000; i++ {
+		ix.add(strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i += 2 {
+		ix.remove(strconv.Itoa(i))
+	}
+	ix.remove("missing")
+	if ix.n != 500 {
+		t.Fatalf("index holds %d names, want 500", ix.n)
+	}
+	names, cursor := ix.scan(0, 1<<30)
+	if cursor != 0 || len(names) != 500 {
+		t.Fatalf("full scan returned %d names and cursor %d", len(names), cursor)
+	}
+	for _, name := range names {
+		if n, _ := strconv.Atoi(name); n%2 == 0 {
+			t.Errorf("removed name %s still returned", name)
+		}
+	}
+	for i := 1; i < 1000; i += 2 {
+		ix.remove(strconv.Itoa(i))
+	}
+	if ix.n != 0 || len(ix.buckets) != scanMinBuckets {
+		t.Fatalf("emptied index holds %d names in %d buckets", ix.n, len(ix.buckets))
+	}
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// setMaxIntsetEntries is set-max-intset-entries: an intset holding more
+// members than this is converted to a hash table
+const setMaxIntsetEntries = 512
+
+// Set keeps sets whose members are all integers as a sorted slice of int64,
+// like Redis' intset, and switches to a Go map for good as soon as a member
+// is not an integer or the set grows past setMaxIntsetEntries.
+type Set struct {
+	ints  []int64             // intset encoding, sorted
+	table map[string]struct{} // nil while the set is an intset
+	index scanIndex           // the members of table, for SSCAN
+}
+
+func NewSet() *Set {
+	return &Set{}
+}
+
+func (s *Set) IsIntset() bool {
+	return s.table == nil
+}
+
+func (s *Set) Len() int {
+	if s.table != nil {
+		return len(s.table)
+	}
+	return len(s.ints)
+}
+
+// search returns where n is, or would be inserted, in the intset
+func (s *Set) search(n int64) (int, bool) {
+	i := sort.Search(len(s.ints), func(i int) bool { return s.ints[i] >= n })
+	return i, i < len(s.ints) && s.ints[i] == n
+}
+
+func (s *Set) Contains(member string) bool {
+	if s.table != nil {
+		_, ok := s.table[member]
+		return ok
+	}
+	n, ok := parseStrictInt(member)
+	if !ok {
+		return false
+	}
+	_, found := s.search(n)
+	return found
+}
+
+// Add inserts member and reports whether it was not there yet
+func (s *Set) Add(member string) bool {
+	if s.table == nil {
+		n, ok := parseStrictInt(member)
+		if ok {
+			i, found := s.search(n)
+			if found {
+				return false
+			}
+			s.ints = append(s.ints, 0)
+			copy(s.ints[i+1:], s.ints[i:])
+			s.ints[i] = n
+			if len(s.ints) > setMaxIntsetEntries {
+				s.convert()
+			}
+			return true
+		}
+		s.convert()
+	}
+	if _, ok := s.table[member]; ok {
+		return false
+	}
+	s.table[member] = struct{}{}
+	s.index.add(member)
+	return true
+}
+
+// Remove deletes member and reports whether it was there
+func (s *Set) Remove(member string) bool {
+	if s.table != nil {
+		_, ok := s.table[member]
+		if ok {
+			delete(s.table, member)
+			s.index.remove(member)
+		}
+		return ok
+	}
+	n, ok := parseStrictInt(member)
+	if !ok {
+		return false
+	}
+	i, found := s.search(n)
+	if !found {
+		return false
+	}
+	s.ints = append(s.ints[:i], s.ints[i+1:]...)
+	return true
+}
+
+// Each calls fn for every member, in ascending order for an intset, until
+// fn returns false
+func (s *Set) Each(fn func(member string) bool) {
+	if s.table != nil {
+		for member := range s.table {
+			if !fn(member) {
+				return
+			}
+		}
+		return
+	}
+	for _, n := range s.ints {
+		if !fn(strconv.FormatInt(n, 10)) {
+			return
+		}
+	}
+}
+
+// Members returns every member, see Each for the order
+func (s *Set) Members() []string {
+	members := make([]string, 0, s.Len())
+	s.Each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members
+}
+
+func (s *Set) convert() {
+	s.table = make(map[string]struct{}, len(s.ints))
+	for _, n := range s.ints {
+		member := strconv.FormatInt(n, 10)
+		s.table[member] = struct{}{}
+		s.index.add(member)
+	}
+	s.ints = nil
+}
+
+// Dup returns a copy of the set in the same representation
+func (s *Set) Dup() *Set {
+	dup := &Set{ints: append([]int64(nil), s.ints...)}
+	if s.table != nil {
+		dup.table = make(map[string]struct{}, len(s.table))
+		for member := range s.table {
+			dup.table[member] = struct{}{}
+		}
+		dup.index = s.index.dup()
+	}
+	return dup
+}
+
+// Random returns a member picked at random; the set must not be empty. An
+// intset picks an index, a map one of a few members walked from a random
+// place, see Hash.Random.
+func (s *Set) Random() string {
+	if s.table != nil {
+		var members [getFairRandomEntries]string
+		n := 0
+		for member := range s.table {
+			members[n] = member
+			if n++; n == len(members) {
+				break
+			}
+		}
+		return members[rand.Intn(n)]
+	}
+	return strconv.FormatInt(s.ints[rand.Intn(len(s.ints))], 10)
+}
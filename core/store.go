@@ -1,74 +1,275 @@
 package core
 
 import (
-	"log"
+	"math/rand"
 	"time"
 )
 
-var store map[string]*Obj
+// redisDb is one of the numbered databases clients switch between with
+// SELECT. Blocked clients wait on keys of a given database, so the queues
+// live here too.
+type redisDb struct {
+	id   int
+	dict map[string]*Obj
+	// keys are the keys of dict, for SCAN. Keys are only added and
+	// removed through set, remove and empty, which keep both in step.
+	keys scanIndex
+	// expires holds the keys of dict that have an expiry, like db->expires
+	// in Redis, so that volatile eviction and active expiry sample only
+	// those. Expiries change through set and setExpire.
+	expires map[string]*Obj
+	// blockingKeys lists the clients waiting on each key, in the order
+	// they blocked
+	blockingKeys map[string][]*Client
+}
+
+// dbs are all the databases and db the one the running command works on.
+// Every key lookup goes through db; selectDb points it at the database of
+// the client before each command, as c->db does in Redis.
+var dbs []*redisDb
+var db *redisDb
+
 var storeConfig *StoreConfig
 
+// defaultDatabases is the number of databases when no config says otherwise
+const defaultDatabases = 16
+
+// Value types, what TYPE reports
+const (
+	ObjString uint8 = iota
+	ObjList
+	ObjSet
+	ObjZSet
+	ObjHash
+)
+
+// Encodings, how a value of a given type is represented in memory
+const (
+	EncRaw       uint8 = iota // string held as a plain Go string
+	EncInt                    // string that is an integer, held as int64
+	EncEmbstr                 // short string (Redis embeds these in the object)
+	EncListpack               // small aggregate kept compact
+	EncQuicklist              // list that outgrew the listpack limits
+	EncHashtable              // hash (or set) held in a Go map
+	EncIntset                 // set of integers kept in a sorted slice
+	EncSkiplist               // sorted set held in a skiplist plus a map
+)
+
+// embstrSizeLimit is the longest string Redis stores with the embstr encoding
+const embstrSizeLimit = 44
+
 type Obj struct {
+	Type      uint8
+	Encoding  uint8
 	Value     interface{}
 	ExpiresAt int64 // absolute time when to expire in milliseconds
+
+	// Access tracking for OBJECT IDLETIME / FREQ and eviction
+	LastAccess int64 // unix time in milliseconds of the last read or write
+	Freq       uint8 // logarithmic access counter, see lfuLogIncr
+}
+
+var objTypeNames = map[uint8]string{
+	ObjString: "string",
+	ObjList:   "list",
+	ObjSet:    "set",
+	ObjZSet:   "zset",
+	ObjHash:   "hash",
+}
+
+var objEncodingNames = map[uint8]string{
+	EncRaw:       "raw",
+	EncInt:       "int",
+	EncEmbstr:    "embstr",
+	EncListpack:  "listpack",
+	EncQuicklist: "quicklist",
+	EncHashtable: "hashtable",
+	EncIntset:    "intset",
+	EncSkiplist:  "skiplist",
+}
+
+// TypeName is the name TYPE replies with
+func (obj *Obj) TypeName() string {
+	return objTypeNames[obj.Type]
+}
+
+// EncodingName is the name OBJECT ENCODING replies with
+func (obj *Obj) EncodingName() string {
+	return objEncodingNames[obj.Encoding]
 }
 
 type StoreConfig struct {
 	KeysLimit        int
 	EvictionStrategy string
+	// Thresholds past which a hash leaves the compact encoding
+	HashMaxListpackEntries int
+	HashMaxListpackValue   int
+	// Databases is how many databases SELECT can pick from
+	Databases int
+	// MaxmemorySamples is how many keys per database an eviction samples
+	MaxmemorySamples int
+	// LFU tunables, see lfuLogIncr and lfuDecr
+	LfuLogFactor int
+	LfuDecayTime int
 }
 
 func init() {
-	store = make(map[string]*Obj)
+	createDbs(defaultDatabases)
 }
 
 // InitStore initializes the store with configuration
 func InitStore(config StoreConfig) {
 	storeConfig = &config
+	if config.Databases > 0 {
+		createDbs(config.Databases)
+	}
+}
+
+func createDbs(n int) {
+	dbs = make([]*redisDb, n)
+	for i := range dbs {
+		dbs[i] = &redisDb{
+			id:           i,
+			blockingKeys: make(map[string][]*Client),
+		}
+		dbs[i].empty()
+	}
+	db = dbs[0]
+	evictionNextDb = 0
+	evictionPool = nil
+}
+
+// set stores obj at key
+func (d *redisDb) set(key string, obj *Obj) {
+	if _, exists := d.dict[key]; !exists {
+		d.keys.add(key)
+	}
+	d.dict[key] = obj
+	if obj.ExpiresAt != -1 {
+		d.expires[key] = obj
+	} else {
+		delete(d.expires, key)
+	}
+}
+
+// setExpire changes the expiry of obj, stored at key, to when (-1 for
+// none)
+func (d *redisDb) setExpire(key string, obj *Obj, when int64) {
+	obj.ExpiresAt = when
+	if when != -1 {
+		d.expires[key] = obj
+	} else {
+		delete(d.expires, key)
+	}
 }
 
+// remove deletes key and reports whether it was there
+func (d *redisDb) remove(key string) bool {
+	if _, ok := d.dict[key]; !ok {
+		return false
+	}
+	delete(d.dict, key)
+	delete(d.expires, key)
+	d.keys.remove(key)
+	return true
+}
+
+// empty drops every key
+func (d *redisDb) empty() {
+	d.dict = make(map[string]*Obj)
+	d.expires = make(map[string]*Obj)
+	d.keys = scanIndex{}
+}
+
+// selectDb makes database id the current one, reporting false when there
+// is no such database
+func selectDb(id int) bool {
+	if id < 0 || id >= len(dbs) {
+		return false
+	}
+	db = dbs[id]
+	return true
+}
+
+// totalKeys is the number of keys over all the databases, which is what
+// the keys limit applies to
+func totalKeys() int {
+	n := 0
+	for _, d := range dbs {
+		n += len(d.dict)
+	}
+	return n
+}
+
+// NewObj creates a string object; value is either a string or an int64,
+// and the encoding follows from it
 func NewObj(value interface{}, durationMs int64) *Obj {
+	encoding := EncRaw
+	switch v := value.(type) {
+	case int64:
+		encoding = EncInt
+	case string:
+		if len(v) <= embstrSizeLimit {
+			encoding = EncEmbstr
+		}
+	}
+	return NewTypedObj(ObjString, encoding, value, durationMs)
+}
+
+// NewTypedObj creates an object of any type with the given encoding
+func NewTypedObj(objType uint8, encoding uint8, value interface{}, durationMs int64) *Obj {
 	expiresAt := int64(-1)
 	if durationMs > 0 {
 		expiresAt = time.Now().UnixMilli() + durationMs
 	}
 	return &Obj{
-		Value:     value,
-		ExpiresAt: expiresAt,
+		Type:       objType,
+		Encoding:   encoding,
+		Value:      value,
+		ExpiresAt:  expiresAt,
+		LastAccess: time.Now().UnixMilli(),
+		Freq:       lfuInitVal,
 	}
 }
 
 func Put(k string, obj *Obj) {
-	log.Printf("Put called for key '%s', current store size: %d, limit: %d", k, len(store),
-		func() int {
-			if storeConfig != nil {
-				return storeConfig.KeysLimit
-			} else {
-				return -1
-			}
-		}())
-
-	// Check if we need to evict before adding new key
-	if storeConfig != nil && len(store) >= storeConfig.KeysLimit {
-		// Only evict if the key doesn't already exist (we're adding a new key)
-		if _, exists := store[k]; !exists {
-			log.Printf("Triggering eviction before adding new key '%s'", k)
-			Evict(storeConfig.EvictionStrategy)
-		} else {
-			log.Printf("Key '%s' already exists, updating without eviction", k)
-		}
-	}
+	// A write counts as an access for LRU; the LFU counter first takes
+	// the decay it was due, which is measured from the last access
+	now := time.Now().UnixMilli()
+	obj.Freq = lfuDecr(obj, now)
+	obj.LastAccess = now
+	db.set(k, obj)
+}
 
-	store[k] = obj
-	log.Printf("Key '%s' stored, new store size: %d", k, len(store))
+// setKey is Put for the string commands that replace the value of a key,
+// SET, INCR and the like. As dbOverwrite does in Redis, under an LFU policy
+// the new value keeps the counter of the one it replaces, so writing a hot
+// key does not make it look new.
+func setKey(k string, obj *Obj) {
+	if old := Peek(k); old != nil && old != obj && isLFUPolicy() {
+		obj.Freq = old.Freq
+		obj.LastAccess = old.LastAccess
+	}
+	Put(k, obj)
 }
 
+// Get returns the live object at k, or nil, and counts it as an access
 func Get(k string) *Obj {
-	v := store[k]
+	v := Peek(k)
+	if v != nil {
+		v.touch()
+	}
+	return v
+}
+
+// Peek is Get for introspection commands: it does not change the access
+// time or frequency of the object
+func Peek(k string) *Obj {
+	v := db.dict[k]
 	if v != nil {
 		if v.ExpiresAt != -1 && time.Now().UnixMilli() >= v.ExpiresAt {
 			// Key has expired, delete it
-			delete(store, k)
+			db.remove(k)
 			return nil
 		}
 		return v
@@ -76,9 +277,25 @@ func Get(k string) *Obj {
 	return nil
 }
 func Del(k string) bool {
-	if _, ok := store[k]; ok {
-		delete(store, k)
-		return true
+	return db.remove(k)
+}
+
+// randomKey returns a live key of the current database picked at random,
+// and false when there is none. Expired keys it lands on are deleted and
+// another one is picked.
+func randomKey() (string, bool) {
+	for len(db.dict) > 0 {
+		n := rand.Intn(len(db.dict))
+		for k := range db.dict {
+			if n > 0 {
+				n--
+				continue
+			}
+			if Peek(k) != nil {
+				return k, true
+			}
+			break
+		}
 	}
-	return false
+	return "", false
 }
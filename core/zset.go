@@ -0,0 +1,433 @@
+package core
+
+import (
+	"math/rand"
+)
+
+// ZSet is a sorted set the way Redis builds it: a skiplist ordered by
+// (score, member) answers range and rank queries, and a map from member to
+// score answers point lookups. Both hold every element.
+type ZSet struct {
+	zsl   *zskiplist
+	dict  map[string]float64
+	index scanIndex // the members, for ZSCAN
+}
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int // how many nodes forward skips over
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{
+		zsl: &zskiplist{
+			header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+			level:  1,
+		},
+		dict: make(map[string]float64),
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// less orders nodes by score, then by member
+func zslLess(score float64, member string, node *zskiplistNode) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(score, member, x.level[i].forward) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(score, member, x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		zsl.deleteNode(x, update)
+		return true
+	}
+	return false
+}
+
+// rank returns the 1 based rank of the element, 0 if it is not there
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.score < score ||
+				(x.level[i].forward.score == score && x.level[i].forward.member <= member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the element at the 1 based rank, or nil
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// zrange is a range over the elements of a sorted set, by score or by
+// member (for elements sharing a score, as ZRANGEBYLEX expects)
+type zrange interface {
+	gteMin(x *zskiplistNode) bool
+	lteMax(x *zskiplistNode) bool
+	empty() bool
+}
+
+type zscoreRange struct {
+	min, max     float64
+	minex, maxex bool // exclusive bounds
+}
+
+func (r zscoreRange) gteMin(x *zskiplistNode) bool {
+	if r.minex {
+		return x.score > r.min
+	}
+	return x.score >= r.min
+}
+
+func (r zscoreRange) lteMax(x *zskiplistNode) bool {
+	if r.maxex {
+		return x.score < r.max
+	}
+	return x.score <= r.max
+}
+
+func (r zscoreRange) empty() bool {
+	return r.min > r.max || (r.min == r.max && (r.minex || r.maxex))
+}
+
+// zlexBound is one end of a lex range: "-" and "+" are the infinities
+type zlexBound struct {
+	value string
+	inf   int // -1 for "-", 1 for "+", 0 for a value
+	ex    bool
+}
+
+type zlexRange struct {
+	min, max zlexBound
+}
+
+func (r zlexRange) gteMin(x *zskiplistNode) bool {
+	switch {
+	case r.min.inf < 0:
+		return true
+	case r.min.inf > 0:
+		return false
+	case r.min.ex:
+		return x.member > r.min.value
+	}
+	return x.member >= r.min.value
+}
+
+func (r zlexRange) lteMax(x *zskiplistNode) bool {
+	switch {
+	case r.max.inf > 0:
+		return true
+	case r.max.inf < 0:
+		return false
+	case r.max.ex:
+		return x.member < r.max.value
+	}
+	return x.member <= r.max.value
+}
+
+func (r zlexRange) empty() bool {
+	if r.min.inf > 0 || r.max.inf < 0 {
+		return true
+	}
+	if r.min.inf < 0 || r.max.inf > 0 {
+		return false
+	}
+	return r.min.value > r.max.value || (r.min.value == r.max.value && (r.min.ex || r.max.ex))
+}
+
+// isInRange reports whether any element may be in the range
+func (zsl *zskiplist) isInRange(r zrange) bool {
+	if r.empty() || zsl.tail == nil {
+		return false
+	}
+	return r.gteMin(zsl.tail) && r.lteMax(zsl.header.level[0].forward)
+}
+
+// firstInRange returns the first element in the range, or nil
+func (zsl *zskiplist) firstInRange(r zrange) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x) {
+		return nil
+	}
+	return x
+}
+
+// lastInRange returns the last element in the range, or nil
+func (zsl *zskiplist) lastInRange(r zrange) *zskiplistNode {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.gteMin(x) {
+		return nil
+	}
+	return x
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add sets the score of member and reports whether it is a new member
+func (z *ZSet) Add(member string, score float64) bool {
+	current, exists := z.dict[member]
+	if exists {
+		if current != score {
+			z.zsl.delete(current, member)
+			z.zsl.insert(score, member)
+			z.dict[member] = score
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	z.index.add(member)
+	return true
+}
+
+// Remove deletes member and reports whether it was there
+func (z *ZSet) Remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	z.index.remove(member)
+	return true
+}
+
+// Rank returns the 0 based rank of member, counted from the highest score
+// when reverse is set
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, exists := z.dict[member]
+	if !exists {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// zsetItem is an element returned by the range queries
+type zsetItem struct {
+	member string
+	score  float64
+}
+
+// RangeByRank returns the elements from rank start to end, both inclusive
+// and within bounds, counting from the highest score when reverse is set
+func (z *ZSet) RangeByRank(start int, end int, reverse bool) []zsetItem {
+	items := make([]zsetItem, 0, end-start+1)
+	var x *zskiplistNode
+	if reverse {
+		x = z.zsl.byRank(z.Len() - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+	for n := start; n <= end && x != nil; n++ {
+		items = append(items, zsetItem{x.member, x.score})
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return items
+}
+
+// RangeBy returns the elements in r, in descending order when reverse is
+// set, skipping offset of them and returning at most limit (all if < 0)
+func (z *ZSet) RangeBy(r zrange, reverse bool, offset int64, limit int64) []zsetItem {
+	items := []zsetItem{}
+	var x *zskiplistNode
+	if reverse {
+		x = z.zsl.lastInRange(r)
+	} else {
+		x = z.zsl.firstInRange(r)
+	}
+	for x != nil && limit != 0 {
+		if reverse && !r.gteMin(x) || !reverse && !r.lteMax(x) {
+			break
+		}
+		if offset > 0 {
+			offset--
+		} else {
+			items = append(items, zsetItem{x.member, x.score})
+			limit--
+		}
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return items
+}
+
+// Count returns how many elements are in r
+func (z *ZSet) Count(r zrange) int {
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(r)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// Each calls fn for every element in ascending order
+func (z *ZSet) Each(fn func(member string, score float64)) {
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		fn(x.member, x.score)
+	}
+}
+
+// Dup returns a copy of the sorted set
+func (z *ZSet) Dup() *ZSet {
+	dup := NewZSet()
+	z.Each(func(member string, score float64) {
+		dup.Add(member, score)
+	})
+	return dup
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestZSetMatchesSortedSlice applies random adds, score updates and
+// removals to a ZSet and to a plain sorted slice, and checks that the rank
+// and range queries, which rely on the skiplist spans, agree after each one
+func TestZSetMatchesSortedSlice(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	z := NewZSet()
+	scores := make(map[string]float64)
+
+	for step := 0; step < 3000; step++ {
+		// Few distinct scores so that many elements are ordered by member
+		member := fmt.Sprintf("m%03d", rnd.Intn(200))
+		if rnd.Intn(10) < 7 {
+			score := float64(rnd.Intn(20))
+			_, exists := scores[member]
+			if added := z.Add(member, score); added == exists {
+				t.Fatalf("step %d: Add(%s) reported new=%v", step, member, added)
+			}
+			scores[member] = score
+		} else {
+			_, exists := scores[member]
+			if removed := z.Remove(member); removed != exists {
+				t.Fatalf("step %d: Remove(%s) reported %v", step, member, removed)
+			}
+			delete(scores, member)
+		}
+
+		want := make([]zsetItem, 0, len(scores))
+		for m, s := range scores {
+			want = append(want, zsetItem{m, s})
+		}
+		sort.Slice(want, func(i, j int) bool {
+			if want[i].score != want[j].score {
+				return want[i].score < want[j].score
+			}
+			return want[i].member < want[j].member
+		})
+		reversed := make([]zsetItem, len(want))
+		for i, item := range want {
+			reversed[len(want)-1-i] = item
+		}
+		n := len(want)
+		if z.Len() != n {
+			t.Fatalf("step %d: Len() = %d, want %d", step, z.Len(), n)
+		}
+
+		for i, item := range want {
+			if rank, ok := z.Rank(item.member, false); !ok || rank != i {
+				t.Fatalf("step %d: Rank(%s) = %d, %v, want %d", step, item.member, rank, ok, i)
+			}
+			if rank, ok := z.Rank(item.member, true); !ok || rank != n-1-i {
+				t.Fatalf("step %d: reverse Rank(%s) = %d, %v, want %d", step, item.member, rank, ok, n-1-i)
+			}
+		}
+		if _, ok := z.Rank("missing", false); ok {
+			t.Fatalf("step %d: Rank found a missing member", step)
+		}
+		if n == 0 {
+			continue
+		}
+
+		start := rnd.Intn(n)
+		end := start + rnd.Intn(n-start)
+		if got := z.RangeByRank(start, end, false); !reflect.DeepEqual(got, want[start:end+1]) {
+			t.Fatalf("step %d: RangeByRank(%d, %d) = %v, want %v", step, start, end, got, want[start:end+1])
+		}
+		if got := z.RangeByRank(start, end, true); !reflect.DeepEqual(got, reversed[start:end+1]) {
+			t.Fatalf("step %d: reverse RangeByRank(%d, %d) = %v, want %v", step, start, end, got, reversed[start:end+1])
+		}
+
+		r := zscoreRange{
+			min: float64(rnd.Intn(22) - 1), max: float64(rnd.Intn(22) - 1),
+			minex: rnd.Intn(2) == 0, maxex: rnd.Intn(2) == 0,
+		}
+		var inRange []zsetItem
+		for _, item := range want {
+			if r.gteMin(&zskiplistNode{score: item.score}) && r.lteMax(&zskiplistNode{score: item.score}) {
+				inRange = append(inRange, item)
+			}
+		}
+		if got := z.Count(r); got != len(inRange) {
+			t.Fatalf("step %d: Count(%+v) = %d, want %d", step, r, got, len(inRange))
+		}
+		offset, limit := int64(rnd.Intn(5)), int64(rnd.Intn(8)-1)
+		for _, reverse := range []bool{false, true} {
+			items := append([]zsetItem(nil), inRange...)
+			if reverse {
+				for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+					items[i], items[j] = items[j], items[i]
+				}
+			}
+			expected := []zsetItem{}
+			if int(offset) < len(items) {
+				expected = append(expected, items[offset:]...)
+			}
+			if limit >= 0 && int(limit) < len(expected) {
+				expected = expected[:limit]
+			}
+			if got := z.RangeBy(r, reverse, offset, limit); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("step %d: RangeBy(%+v, reverse=%v, %d, %d) = %v, want %v", step, r, reverse, offset, limit, got, expected)
+			}
+		}
+	}
+}
+
+func TestZRANKReplies(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"ZRANK z b", ":1\r\n"},
+		{"ZREVRANK z b WITHSCORE", "*2\r\n:0\r\n$1\r\n2\r\n"},
+		{"ZRANK z missing", "$-1\r\n"},
+		{"ZRANK z missing WITHSCORE", "*-1\r\n"},
+		{"ZRANK nokey a WITHSCORE", "*-1\r\n"},
+		{"ZRANK z a WITHSCORES", "-ERR syntax error\r\n"},
+	}
+	resetServer(t)
+	c := NewClient(-1, "", "")
+	run(c, "ZADD z 1 a 2 b")
+	for _, tt := range tests {
+		if got := run(c, tt.cmd); got != tt.want {
+			t.Errorf("%s replied %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -31,6 +31,13 @@ func main() {
 	storeConfig := core.StoreConfig{
 		KeysLimit:        appConfig.KeysLimit,
 		EvictionStrategy: appConfig.EvictionStrategy,
+
+		HashMaxListpackEntries: appConfig.HashMaxListpackEntries,
+		HashMaxListpackValue:   appConfig.HashMaxListpackValue,
+		Databases:              appConfig.Databases,
+		MaxmemorySamples:       appConfig.MaxmemorySamples,
+		LfuLogFactor:           appConfig.LfuLogFactor,
+		LfuDecayTime:           appConfig.LfuDecayTime,
 	}
 	core.InitStore(storeConfig)
 
@@ -43,6 +50,12 @@ func main() {
 		AutoDeleteFrequency: appConfig.AutoDeleteFrequency,
 		MaxClients:          appConfig.MaxClients,
 		LogLevel:            appConfig.LogLevel,
+		Timeout:             appConfig.Timeout,
+		TCPKeepalive:        appConfig.TCPKeepalive,
+
+		OutputBufferHardLimit:   appConfig.OutputBufferHardLimit,
+		OutputBufferSoftLimit:   appConfig.OutputBufferSoftLimit,
+		OutputBufferSoftSeconds: appConfig.OutputBufferSoftSeconds,
 	}
 
 	// Start the async TCP server
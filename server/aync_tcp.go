@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -55,6 +56,7 @@ func RunAsyncTCPServer(config Config) error {
 	log.Printf("Starting Async TCP server on %s:%d", config.Host, config.Port)
 	log.Printf("Configuration: MaxClients=%d, KeysLimit=%d, EvictionStrategy=%s",
 		config.MaxClients, config.KeysLimit, config.EvictionStrategy)
+	core.SetOutputBufferHardLimit(config.OutputBufferHardLimit)
 
 	//maximum clients to be accepted from config
 	max_clients := config.MaxClients
@@ -148,6 +150,83 @@ func RunAsyncTCPServer(config Config) error {
 	/* creting events for EpollWait to hold the object */
 	var events []syscall.EpollEvent = make([]syscall.EpollEvent, max_clients)
 
+	/* per client state (query buffer) keyed by the client fd */
+	var connections map[int]*Connection = make(map[int]*Connection)
+
+	closeClient := func(fd int) {
+		con_clients--
+		if conn, ok := connections[fd]; ok {
+			core.FreeClient(conn.client)
+		}
+		delete(connections, fd)
+		syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_DEL, fd, nil)
+		syscall.Close(fd)
+	}
+
+	// Only ask for EPOLLOUT while replies are waiting, otherwise epoll would
+	// wake us up for every writable socket on every iteration
+	updateWriteInterest := func(fd int, c *Connection) {
+		want := c.Pending() > 0
+		if want == c.writeWatched {
+			return
+		}
+		var clientEvents uint32 = syscall.EPOLLIN | syscall.EPOLLHUP | syscall.EPOLLERR
+		if want {
+			clientEvents |= syscall.EPOLLOUT
+		}
+		err := syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
+			Events: clientEvents,
+			Fd:     int32(fd),
+		})
+		if err != nil {
+			log.Printf("Error updating epoll events for fd %d: %v\n", fd, err)
+			return
+		}
+		c.writeWatched = want
+	}
+
+	// Drop a client that lets its replies pile up. Checked whenever replies
+	// are queued or flushed, and from the cron so that a client which stops
+	// reading and sending still reaches the soft limit deadline.
+	overOutputLimits := func(fd int, c *Connection) bool {
+		if !OverOutputLimits(c, config.OutputBufferHardLimit, config.OutputBufferSoftLimit, config.OutputBufferSoftSeconds) {
+			return false
+		}
+		log.Printf("Closing fd %d: output buffer limit reached (%d bytes pending)\n", fd, c.Pending())
+		closeClient(fd)
+		return true
+	}
+
+	// Send the replies of the clients released from a blocking command and
+	// run what they pipelined behind it, which may release others in turn
+	serveUnblocked := func() {
+		for {
+			unblocked := core.UnblockedClients()
+			if len(unblocked) == 0 {
+				return
+			}
+			for _, client := range unblocked {
+				conn, ok := connections[client.FD]
+				if !ok || conn.client != client {
+					continue
+				}
+				ResumeUnblocked(conn)
+				if err := Flush(conn); err != nil {
+					closeClient(client.FD)
+					continue
+				}
+				if overOutputLimits(client.FD, conn) {
+					continue
+				}
+				if client.Flags&core.ClientCloseAfterReply != 0 && conn.Pending() == 0 {
+					closeClient(client.FD)
+					continue
+				}
+				updateWriteInterest(client.FD, conn)
+			}
+		}
+	}
+
 	/* Run the loop
 	It will accept the client and add the client to the epoll list */
 	for {
@@ -156,12 +235,37 @@ func RunAsyncTCPServer(config Config) error {
 		if time.Now().After(lastCronExecTime.Add(cronFreq)) {
 			//if true , lets delete
 			core.DeleteExpireKeys()
+			//drop the clients that went quiet for too long
+			if config.Timeout > 0 {
+				maxIdle := time.Duration(config.Timeout) * time.Second
+				for fd, conn := range connections {
+					// blocked clients are waiting on purpose
+					if conn.client.IsBlocked() {
+						continue
+					}
+					if time.Since(conn.client.LastInteraction) > maxIdle {
+						closeClient(fd)
+					}
+				}
+			}
+			for fd, conn := range connections {
+				overOutputLimits(fd, conn)
+			}
 			//update the current time to last delete operation time
 			lastCronExecTime = time.Now()
 		}
+		// Blocked clients are timed out on every iteration, not just once a
+		// second, and the wait below never sleeps past the next deadline
+		core.TimeoutBlockedClients()
+		serveUnblocked()
+
 		/* check if any FD is ready for IO */
 		// Use timeout of 1000ms (1 second) to ensure auto-deletion runs regularly
-		nevents, e := syscall.EpollWait(epollFD, events, 1000)
+		waitMs := 1000
+		if next := core.NextBlockedTimeout(); next >= 0 && next < waitMs {
+			waitMs = next
+		}
+		nevents, e := syscall.EpollWait(epollFD, events, waitMs)
 		if e != nil {
 			log.Printf("EpollWait error: %v\n", e)
 			continue
@@ -173,26 +277,34 @@ func RunAsyncTCPServer(config Config) error {
 		for i := 0; i < nevents; i++ {
 			//if the IO means for server socket , it is a new client connection
 			if int(events[i].Fd) == serverFD {
-				fd, _, err := syscall.Accept(serverFD)
+				fd, sa, err := syscall.Accept(serverFD)
 				if err != nil {
 					log.Println("err", err)
 					continue
 				}
+				// Refuse the connection once we are at the limit, telling
+				// the client why (best effort, the socket is still blocking)
+				if con_clients >= max_clients {
+					syscall.Write(fd, []byte("-ERR max number of clients reached\r\n"))
+					syscall.Close(fd)
+					continue
+				}
 				con_clients++
-				// Extract client IP and port from sockaddr
-				// Uncomment below if you want to log client connections:
-				/*
-				   if sockAddr, ok := addr.(*syscall.SockaddrInet4); ok {
-				   	clientIP := fmt.Sprintf("%d.%d.%d.%d:%d",
-				   		sockAddr.Addr[0], sockAddr.Addr[1], sockAddr.Addr[2], sockAddr.Addr[3],
-				   		sockAddr.Port)
-				   	log.Printf("Client connected: %s, concurrent clients: %d\n", clientIP, con_clients)
-				   } else {
-				   	//log.Printf("Client connected (unknown address), concurrent clients: %d\n", con_clients)
-				   }
-				*/
+				// Extract client IP and port from sockaddr, and the local
+				// address it connected to, for CLIENT LIST
+				clientAddr := sockaddrString(sa)
+				localAddr := ""
+				if lsa, err := syscall.Getsockname(fd); err == nil {
+					localAddr = sockaddrString(lsa)
+				}
+				//log.Printf("Client connected: %s, concurrent clients: %d\n", clientAddr, con_clients)
 
 				syscall.SetNonblock(fd, true) // Fix: set client fd to non-blocking
+				if config.TCPKeepalive > 0 {
+					if err := setKeepAlive(fd, config.TCPKeepalive); err != nil {
+						log.Printf("Error enabling keepalive on fd %d: %v\n", fd, err)
+					}
+				}
 				//add this fd to be monitored for IO
 				var socketClientEvent syscall.EpollEvent = syscall.EpollEvent{
 					Events: syscall.EPOLLIN | syscall.EPOLLHUP | syscall.EPOLLERR,
@@ -204,51 +316,131 @@ func RunAsyncTCPServer(config Config) error {
 					log.Printf("Error adding client fd %d to epoll: %v\n", fd, err)
 					syscall.Close(fd)
 					con_clients--
+					continue
 				}
+				connections[fd] = NewConnection(&FDConn{fd: fd}, core.NewClient(fd, clientAddr, localAddr))
 			} else {
 				/* if here means IO from an existing client */
 				clientFD := int(events[i].Fd)
 
 				// Check for error or hangup events
 				if events[i].Events&(syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
-					con_clients--
-					syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_DEL, clientFD, nil)
-					syscall.Close(clientFD)
+					closeClient(clientFD)
 					continue
 				}
 
-				// Create wrapper to use with ReadCommand
-				conn := &FDConn{fd: clientFD}
+				conn, ok := connections[clientFD]
+				if !ok {
+					log.Printf("No connection state for fd: %d\n", clientFD)
+					continue
+				}
 
-				command, err := ReadCommand(conn)
-				if err != nil {
+				// The socket drained enough to take more of the queued replies
+				if events[i].Events&syscall.EPOLLOUT != 0 {
+					if err := Flush(conn); err != nil {
+						closeClient(clientFD)
+						continue
+					}
+					if overOutputLimits(clientFD, conn) {
+						continue
+					}
+					updateWriteInterest(clientFD, conn)
+					if conn.client.Flags&core.ClientCloseAfterReply != 0 && conn.Pending() == 0 {
+						closeClient(clientFD)
+						continue
+					}
+					if events[i].Events&syscall.EPOLLIN == 0 {
+						continue
+					}
+				}
+
+				// Waiting for the last replies to drain before closing,
+				// anything the client sends meanwhile is ignored
+				if conn.client.Flags&core.ClientCloseAfterReply != 0 {
+					continue
+				}
+
+				commands, readErr := ReadCommands(conn)
+				if readErr != nil {
 					// Check if it's a non-blocking "would block" error
-					if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+					if readErr == syscall.EAGAIN || readErr == syscall.EWOULDBLOCK {
 						continue // No data available right now
 					}
-					// Client disconnected or other error
-					//log.Printf("Client disconnected (fd: %d), error: %v, concurrent clients: %d\n", clientFD, err, con_clients-1)
-					con_clients--
-					syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_DEL, clientFD, nil)
-					syscall.Close(clientFD)
-					continue
+					// Client disconnected or other error. Commands decoded
+					// before a protocol error are still answered below.
+					if !errors.Is(readErr, core.ErrProtocol) {
+						//log.Printf("Client disconnected (fd: %d), error: %v, concurrent clients: %d\n", clientFD, readErr, con_clients-1)
+						closeClient(clientFD)
+						continue
+					}
 				}
 
-				if command == nil {
-					// No data read, but no error - shouldn't happen with epoll
-					log.Printf("No command read from fd: %d\n", clientFD)
+				// A single read may carry several pipelined commands (or none,
+				// if the command is still arriving); run them in order
+				ProcessCommands(conn, commands)
+
+				// Malformed input: report it and drop only this client
+				if readErr != nil {
+					log.Printf("Protocol error from fd %d: %v\n", clientFD, readErr)
+					ReplyProtocolError(conn, readErr)
+					Flush(conn)
+					closeClient(clientFD)
 					continue
 				}
 
-				err = Respond(conn, command)
-				if err != nil {
+				if err := Flush(conn); err != nil {
 					log.Printf("Error responding (fd: %d): %v, concurrent clients: %d\n", clientFD, err, con_clients-1)
-					con_clients--
-					syscall.EpollCtl(epollFD, syscall.EPOLL_CTL_DEL, clientFD, nil)
-					syscall.Close(clientFD)
+					closeClient(clientFD)
+					continue
+				}
+				if overOutputLimits(clientFD, conn) {
+					continue
+				}
+				if conn.client.Flags&core.ClientCloseAfterReply != 0 && conn.Pending() == 0 {
+					closeClient(clientFD)
+					continue
 				}
+				updateWriteInterest(clientFD, conn)
 			}
 		}
 
+		// Answer the clients that a write or a timeout released
+		serveUnblocked()
+
+		// Clients killed by CLIENT KILL from another connection
+		for _, killed := range core.ClientsToClose() {
+			if conn, ok := connections[killed.FD]; ok && conn.client == killed {
+				Flush(conn)
+				closeClient(killed.FD)
+			}
+		}
+	}
+}
+
+// setKeepAlive turns on TCP keepalive so dead peers are detected. Like Redis,
+// the first probe is sent after interval seconds of silence, then probes go
+// out every interval/3 seconds and the peer is dropped after 3 misses.
+func setKeepAlive(fd int, interval int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, interval); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, max(interval/3, 1)); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, 3)
+}
+
+// sockaddrString formats an accepted peer or local address as ip:port
+func sockaddrString(sa syscall.Sockaddr) string {
+	switch addr := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return fmt.Sprintf("%d.%d.%d.%d:%d",
+			addr.Addr[0], addr.Addr[1], addr.Addr[2], addr.Addr[3], addr.Port)
+	case *syscall.SockaddrInet6:
+		return fmt.Sprintf("[%s]:%d", net.IP(addr.Addr[:]).String(), addr.Port)
 	}
+	return ""
 }
@@ -1,48 +1,200 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"redis-internal/core"
 	"strings"
+	"syscall"
+	"time"
 )
 
-func ReadCommand(conn io.ReadWriter) (*core.RedisCmd, error) {
-	readBuffer := make([]byte, 1024) // read 1KB data
-	//read is a blocking call - waits until data arrives
-	n, err := conn.Read(readBuffer)
+// readChunkSize is how much we try to read from a socket in one go
+const readChunkSize = 16 * 1024
+
+// maxQueryBufLen caps the bytes buffered for a single client while waiting
+// for a command to complete (same default as Redis' client-query-buffer-limit)
+const maxQueryBufLen = 1024 * 1024 * 1024
+
+// The servers are single threaded, so one read buffer can be shared
+var readBuffer []byte = make([]byte, readChunkSize)
+
+// Connection holds the per-client state that has to survive between reads.
+// A command split across several TCP reads stays in queryBuf until the rest
+// of it arrives.
+type Connection struct {
+	rw       io.ReadWriter
+	queryBuf []byte
+	client   *core.Client // protocol version and other per-client settings
+
+	// Replies are queued in client.Out and written as the socket accepts
+	// them; client.Out[:sent] has already gone out.
+	sent int
+	// writeWatched is set while the fd is registered for EPOLLOUT
+	writeWatched bool
+	// softLimitSince is when the output buffer went over the soft limit
+	softLimitSince time.Time
+	// pending holds the commands that arrived while the client was blocked
+	pending []*core.RedisCmd
+}
+
+func NewConnection(rw io.ReadWriter, client *core.Client) *Connection {
+	return &Connection{
+		rw:     rw,
+		client: client,
+	}
+}
+
+// ReadCommands reads the data available on the connection into its query
+// buffer and returns every complete command the buffer now holds, in the
+// order they were sent. Pipelined commands therefore all come back from a
+// single call, and a partial command is kept for the next one.
+func ReadCommands(c *Connection) ([]*core.RedisCmd, error) {
+	//read is a blocking call for plain sockets - waits until data arrives
+	n, err := c.rw.Read(readBuffer)
 	if err != nil {
 		return nil, err
 	}
-	if n == 0 {
-		// fmt.Println("No data read")
-		return nil, nil
+	c.queryBuf = append(c.queryBuf, readBuffer[:n]...)
+	c.client.LastInteraction = time.Now()
+
+	//fmt.Printf("Raw data received: %q\n", string(c.queryBuf))
+
+	var commands []*core.RedisCmd
+	pos := 0
+	for pos < len(c.queryBuf) {
+		// Anything that does not open with a multibulk header is an inline
+		// command typed by hand (telnet, nc), just as Redis decides
+		decode := core.DecodeCmd
+		if c.queryBuf[pos] != '*' {
+			decode = core.DecodeInlineCmd
+		}
+		tokens, consumed, err := decode(c.queryBuf[pos:])
+		if err == core.ErrIncomplete {
+			break
+		}
+		if err != nil {
+			// fmt.Printf("Decode Cmd failed: %v\n", err)
+			return commands, err
+		}
+		pos += consumed
+		if len(tokens) == 0 {
+			continue
+		}
+		//change the token into redis command format
+		commands = append(commands, &core.RedisCmd{
+			Cmd:  strings.ToUpper(tokens[0]),
+			Args: tokens[1:],
+		})
 	}
 
-	//fmt.Printf("Raw command received: %q\n", string(readBuffer[:n]))
+	// Drop the consumed bytes and keep whatever partial command is left
+	c.queryBuf = append(c.queryBuf[:0], c.queryBuf[pos:]...)
+	c.client.QueryBufLen = len(c.queryBuf)
+	if len(c.queryBuf) > maxQueryBufLen {
+		return commands, fmt.Errorf("query buffer limit exceeded")
+	}
+	return commands, nil
+}
 
-	// Parse with simplified RESP parser
-	tokens, err := core.DecodeCmd(readBuffer[:n])
-	if err != nil {
-		// fmt.Printf("Decode Cmd failed: %v\n", err)
-		return nil, err
+// ReplyProtocolError queues the reason the connection is about to be
+// dropped, mirroring the "-ERR Protocol error: ..." reply of Redis.
+func ReplyProtocolError(c *Connection, err error) {
+	if !errors.Is(err, core.ErrProtocol) {
+		return
 	}
-	//change the token into redis command format
-	return &core.RedisCmd{
-		Cmd:  strings.ToUpper(tokens[0]),
-		Args: tokens[1:],
-	}, nil
+	c.client.Out = core.AppendValue(c.client.Out, fmt.Errorf("ERR %v", err), c.client.Proto)
 }
 
-//
+// Respond evaluates the command, which appends its reply to the output
+// buffer. Nothing is written until Flush is called, so the replies to a
+// pipeline go out together.
+func Respond(c *Connection, Command *core.RedisCmd) {
+	// Evaluate against the client's state (protocol version etc.)
+	core.EvalAndResponse(Command, c.client)
+	c.client.OutBufLen = c.Pending()
+}
 
-func Respond(conn io.ReadWriter, Command *core.RedisCmd) error {
-	// Send RESP Simple String response
-	// Send simple OK response
-	response := core.EvalAndResponse(Command)
-	//fmt.Printf("Raw response sent: %q\n", string(response))
-	_, err := conn.Write([]byte(response))
-	if err != nil {
-		return err
+// ProcessCommands answers the commands in order, after any still pending
+// from earlier. It stops as soon as one of them blocks the client; the rest
+// are kept until ResumeUnblocked is called.
+func ProcessCommands(c *Connection, commands []*core.RedisCmd) {
+	commands = append(c.pending, commands...)
+	c.pending = nil
+	for i, command := range commands {
+		if c.client.Flags&core.ClientCloseAfterReply != 0 {
+			return
+		}
+		if c.client.IsBlocked() {
+			c.pending = commands[i:]
+			return
+		}
+		Respond(c, command)
 	}
+}
+
+// ResumeUnblocked queues the reply of the blocking command the client was
+// released from and runs the commands that waited behind it
+func ResumeUnblocked(c *Connection) {
+	c.client.Out = append(c.client.Out, c.client.TakeBlockedReply()...)
+	c.client.OutBufLen = c.Pending()
+	ProcessCommands(c, nil)
+}
+
+// Pending returns how many queued reply bytes have not been written yet
+func (c *Connection) Pending() int {
+	return len(c.client.Out) - c.sent
+}
+
+// keep at most this much output buffer capacity around once it is drained,
+// so one huge reply doesn't pin its memory for the life of the connection
+const maxIdleOutBufCap = 64 * 1024
+
+// Flush writes as much of the output buffer as the socket accepts. On a
+// non-blocking socket a full send buffer (EAGAIN) is not an error: the rest
+// stays queued and the event loop retries once the fd is writable again.
+func Flush(c *Connection) error {
+	for c.Pending() > 0 {
+		n, err := c.rw.Write(c.client.Out[c.sent:])
+		if n > 0 {
+			c.sent += n
+		}
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+				break
+			}
+			return err
+		}
+	}
+	if c.Pending() == 0 {
+		if cap(c.client.Out) > maxIdleOutBufCap {
+			c.client.Out = nil
+		} else {
+			c.client.Out = c.client.Out[:0]
+		}
+		c.sent = 0
+	}
+	c.client.OutBufLen = c.Pending()
 	return nil
 }
+
+// OverOutputLimits reports whether the client should be disconnected for
+// not reading its replies: the pending output is above the hard limit, or
+// has stayed above the soft limit for longer than softSeconds. A limit of 0
+// disables that check.
+func OverOutputLimits(c *Connection, hard int, soft int, softSeconds int) bool {
+	pending := c.Pending()
+	if hard > 0 && pending > hard {
+		return true
+	}
+	if soft > 0 && pending > soft {
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = time.Now()
+			return false
+		}
+		return time.Since(c.softLimitSince) > time.Duration(softSeconds)*time.Second
+	}
+	c.softLimitSince = time.Time{}
+	return false
+}
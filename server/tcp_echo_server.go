@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"redis-internal/core"
 	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +19,13 @@ type Config struct {
 	AutoDeleteFrequency string
 	MaxClients          int
 	LogLevel            string
+	Timeout             int // seconds a client may stay idle, 0 disables
+	TCPKeepalive        int // keepalive interval in seconds, 0 disables
+	// Per-client output buffer limits in bytes (0 disables). A client over
+	// the hard limit, or over the soft one for SoftSeconds, is disconnected.
+	OutputBufferHardLimit   int
+	OutputBufferSoftLimit   int
+	OutputBufferSoftSeconds int
 }
 
 func TcpEchoServer(config Config) {
@@ -33,24 +44,67 @@ func TcpEchoServer(config Config) {
 		}
 		concurrent_client++
 		// fmt.Printf("Accepet conection: %v concurrent client : %v\n", conn.RemoteAddr(), concurrent_client)
+		client := NewConnection(conn, core.NewClient(-1, conn.RemoteAddr().String(), conn.LocalAddr().String()))
 		/* read the command and echo same to the server  continuously till client closed */
 		for {
-			command, err := ReadCommand(conn)
+			commands, err := ReadCommands(client)
 			if err != nil {
 				/* EOF error recieved when client disconnected or close the session */
 				if err == io.EOF {
 					fmt.Println("clinet Disconnected ", conn.RemoteAddr())
 					concurrent_client--
+					core.FreeClient(client.client)
 					fmt.Println("Closing the Current connection and ready to accept new client")
 					break
 				}
-				panic(err)
+				/* a reset or any other read failure only ends this connection */
+				if !errors.Is(err, core.ErrProtocol) {
+					log.Printf("Error reading from %v: %v\n", conn.RemoteAddr(), err)
+					conn.Close()
+					core.FreeClient(client.client)
+					concurrent_client--
+					break
+				}
+			}
+			// fmt.Println("command recived :", commands)
+			//answer every command in the order it was sent
+			ProcessCommands(client, commands)
+			/* nobody else can write while we serve this client, so a
+			blocked client can only time out; wait for its deadline */
+			for client.client.IsBlocked() {
+				wait := core.NextBlockedTimeout()
+				if wait < 0 {
+					wait = 1000
+				}
+				time.Sleep(time.Duration(wait) * time.Millisecond)
+				core.TimeoutBlockedClients()
+				for range core.UnblockedClients() {
+					ResumeUnblocked(client)
+				}
 			}
-			// fmt.Println("command recived :", command)
-			//return the same string to the client
-			err = Respond(conn, command)
+			/* malformed request, tell the client and drop the connection */
 			if err != nil {
-				panic(err)
+				ReplyProtocolError(client, err)
+				Flush(client)
+				conn.Close()
+				core.FreeClient(client.client)
+				concurrent_client--
+				break
+			}
+			/* the socket is blocking, so this writes every queued reply */
+			if err := Flush(client); err != nil {
+				log.Printf("Error responding to %v: %v\n", conn.RemoteAddr(), err)
+				conn.Close()
+				core.FreeClient(client.client)
+				concurrent_client--
+				break
+			}
+			/* CLIENT KILL, only this client can be killed as we serve one at a time */
+			if client.client.Flags&(core.ClientCloseAfterReply|core.ClientCloseASAP) != 0 {
+				conn.Close()
+				core.FreeClient(client.client)
+				concurrent_client--
+				break
 			}
 		}
 